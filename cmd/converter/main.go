@@ -10,5 +10,7 @@ import (
 
 func main() {
 	err := app.RunConverter()
-	logger.Fatal(context.Background(), fmt.Errorf("converter failed to start: %w", err))
+	if err != nil {
+		logger.Fatal(context.Background(), fmt.Errorf("converter failed: %w", err))
+	}
 }
@@ -9,11 +9,13 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/katiasuya/audio-conversion-service/internal/auth"
 	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/events"
 	"github.com/katiasuya/audio-conversion-service/internal/logger"
 	"github.com/katiasuya/audio-conversion-service/internal/queue"
 	"github.com/katiasuya/audio-conversion-service/internal/repository"
 	"github.com/katiasuya/audio-conversion-service/internal/server"
 	"github.com/katiasuya/audio-conversion-service/internal/storage"
+	"github.com/katiasuya/audio-conversion-service/internal/workdir"
 )
 
 // Run runs the application service.
@@ -37,11 +39,17 @@ func RunAPI() error {
 
 	repo := repository.New(db)
 
-	storage, err := storage.NewS3Client(&conf.AWSData)
+	fileStorage, err := newStorage(conf)
 	if err != nil {
-		return fmt.Errorf("can't connect to S3: %w", err)
+		return err
+	}
+	logger.Info(ctx, fmt.Sprintf("%s storage initialized successfully", conf.StorageType))
+
+	removed, err := workdir.Prepare(conf.WorkDir, conf.WorkDirMaxAge)
+	if err != nil {
+		return err
 	}
-	logger.Info(ctx, "connected to S3 successfully")
+	logger.Info(ctx, fmt.Sprintf("work directory %s prepared, %d orphaned files removed", conf.WorkDir, removed))
 
 	conn, ch, err := queue.NewRabbitMQClient(&conf.RabbitMQData)
 	if err != nil {
@@ -51,12 +59,25 @@ func RunAPI() error {
 	defer ch.Close()
 	logger.Info(ctx, "connected to RabbitMQ successfully")
 
-	queueMgr := queue.New(conf.QueueName, ch, nil)
-	tokenMgr := auth.New(&conf.JWTKeys)
+	listener, err := repository.NewPostgresListener(&conf.PostgresData, events.Channel)
+	if err != nil {
+		return fmt.Errorf("can't listen to database notifications: %w", err)
+	}
+	defer listener.Close()
+
+	hub := events.NewHub(listener)
+	go hub.Run(ctx)
+	logger.Info(ctx, "listening to request status changes")
 
-	server := server.New(repo, storage, tokenMgr, queueMgr)
+	queueMgr := queue.New(&conf.RabbitMQData, ch, nil)
+	tokenMgr := auth.New(&conf.JWTKeys, repo)
+
+	server := server.New(repo, fileStorage, tokenMgr, queueMgr, hub, conf.QuotaData, conf.WorkDir)
 
 	r := mux.NewRouter()
+	if localStorage, ok := fileStorage.(*storage.LocalStorage); ok {
+		r.PathPrefix("/files/").Handler(http.StripPrefix("/files/", localStorage.Handler()))
+	}
 	server.RegisterRoutes(r)
 
 	logger.Info(ctx, "start listening on :8000")
@@ -2,19 +2,35 @@ package app
 
 import (
 	"context"
+	"expvar"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/katiasuya/audio-conversion-service/internal/config"
 	"github.com/katiasuya/audio-conversion-service/internal/converter"
+	"github.com/katiasuya/audio-conversion-service/internal/janitor"
 	"github.com/katiasuya/audio-conversion-service/internal/logger"
 	"github.com/katiasuya/audio-conversion-service/internal/queue"
 	"github.com/katiasuya/audio-conversion-service/internal/repository"
-	"github.com/katiasuya/audio-conversion-service/internal/storage"
+	"github.com/katiasuya/audio-conversion-service/internal/webhook"
+	"github.com/katiasuya/audio-conversion-service/internal/workdir"
 )
 
-// Run runs the application service.
+// RunConverter runs the converter service until it receives a termination signal.
 func RunConverter() error {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logger.Info(ctx, fmt.Sprintf("received %s signal, shutting down", sig))
+		cancel()
+	}()
 
 	conf, err := config.Load()
 	if err != nil {
@@ -31,11 +47,17 @@ func RunConverter() error {
 
 	repo := repository.New(db)
 
-	storage, err := storage.NewS3Client(&conf.AWSData)
+	fileStorage, err := newStorage(conf)
+	if err != nil {
+		return err
+	}
+	logger.Info(ctx, fmt.Sprintf("%s storage initialized successfully", conf.StorageType))
+
+	removed, err := workdir.Prepare(conf.WorkDir, conf.WorkDirMaxAge)
 	if err != nil {
-		return fmt.Errorf("can't connect to S3: %w", err)
+		return err
 	}
-	logger.Info(ctx, "connected to S3 successfully")
+	logger.Info(ctx, fmt.Sprintf("work directory %s prepared, %d orphaned files removed", conf.WorkDir, removed))
 
 	conn, ch, err := queue.NewRabbitMQClient(&conf.RabbitMQData)
 	if err != nil {
@@ -45,10 +67,42 @@ func RunConverter() error {
 	defer ch.Close()
 	logger.Info(ctx, "connected to RabbitMQ successfully")
 
-	converter := converter.New(repo, storage)
-	logger.Info(ctx, "converter initialized successfully")
+	notifier := webhook.New(&conf.WebhookData, repo)
+	// The notifier outlives the workers, so that notifications of conversions finished during
+	// the shutdown are sent, and stops when the workers have stopped. Notifications it doesn't send
+	// stay in the database and are sent after the restart.
+	notifierCtx, stopNotifier := context.WithCancel(context.Background())
+	notifierStopped := make(chan struct{})
+	go func() {
+		notifier.Run(notifierCtx)
+		close(notifierStopped)
+	}()
+	defer func() {
+		stopNotifier()
+		<-notifierStopped
+	}()
+	converter := converter.New(repo, fileStorage, notifier, conf.WorkDir)
+	logger.Info(ctx, fmt.Sprintf("converter initialized successfully with %d workers", conf.Workers))
 
-	queue := queue.New(conf.QueueName, ch, converter)
+	queue := queue.New(&conf.RabbitMQData, ch, converter)
+
+	janitor := janitor.New(&conf.RetentionData, repo, fileStorage)
+	go janitor.Run(ctx)
+	logger.Info(ctx, fmt.Sprintf("janitor started, running every %s", conf.JanitorInterval))
+
+	go func() {
+		logger.Info(ctx, "serving metrics on "+conf.MetricsAddr)
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		err := http.ListenAndServe(conf.MetricsAddr, mux)
+		logger.Error(ctx, fmt.Errorf("can't serve metrics: %w", err))
+	}()
+
+	err = queue.ProcessMsgs(ctx, &conf.ConverterData)
+	if err != nil {
+		return fmt.Errorf("can't process queue messages: %w", err)
+	}
+	logger.Info(ctx, "converter stopped")
 
-	return fmt.Errorf("can't process queue messages: %w", queue.ProcessMsgs())
+	return nil
 }
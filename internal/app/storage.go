@@ -0,0 +1,28 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/storage"
+)
+
+// newStorage creates the file storage of the type set in the configuration.
+func newStorage(conf *config.Config) (storage.Storage, error) {
+	switch conf.StorageType {
+	case storage.TypeS3:
+		s3Storage, err := storage.NewS3Client(&conf.AWSData)
+		if err != nil {
+			return nil, fmt.Errorf("can't connect to S3: %w", err)
+		}
+		return s3Storage, nil
+	case storage.TypeLocal:
+		localStorage, err := storage.NewLocalStorage(&conf.StorageData)
+		if err != nil {
+			return nil, fmt.Errorf("can't create local storage: %w", err)
+		}
+		return localStorage, nil
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", conf.StorageType)
+	}
+}
@@ -7,6 +7,8 @@ type key int
 
 const (
 	userIDKey key = iota
+	roleKey
+	tokenIDKey
 )
 
 // AddUserID adds user id to context.
@@ -19,3 +21,25 @@ func GetUserID(ctx context.Context) (string, bool) {
 	userIDctx, ok := ctx.Value(userIDKey).(string)
 	return userIDctx, ok
 }
+
+// AddRole adds user role to context.
+func AddRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey, role)
+}
+
+// GetRole gets user role from context.
+func GetRole(ctx context.Context) (string, bool) {
+	roleCtx, ok := ctx.Value(roleKey).(string)
+	return roleCtx, ok
+}
+
+// AddTokenID adds access token id to context.
+func AddTokenID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tokenIDKey, id)
+}
+
+// GetTokenID gets access token id from context.
+func GetTokenID(ctx context.Context) (string, bool) {
+	tokenIDCtx, ok := ctx.Value(tokenIDKey).(string)
+	return tokenIDCtx, ok
+}
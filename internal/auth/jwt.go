@@ -2,53 +2,94 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	"github.com/katiasuya/audio-conversion-service/internal/config"
 )
 
+// ErrTokenRevoked is returned when the access token has been revoked.
+var ErrTokenRevoked = errors.New("the token has been revoked")
+
+// APIKeyPrefix is the prefix of all API keys.
+const APIKeyPrefix = "acs_"
+
+// RevocationList stores ids of revoked access tokens until they expire.
+type RevocationList interface {
+	RevokeToken(tokenID string, expires time.Time) error
+	IsTokenRevoked(tokenID string) (bool, error)
+}
+
 // TokenManager provides methods to use jwt and contains private and public keys.
 type TokenManager struct {
-	privateKey string
-	publicKey  string
+	privateKey      string
+	publicKey       string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	revoked         RevocationList
 }
 
-// New returns new token manager with the given keys.
-func New(conf *config.JWTKeys) *TokenManager {
+// New returns new token manager with the given keys and revocation list.
+func New(conf *config.JWTKeys, revoked RevocationList) *TokenManager {
 	return &TokenManager{
-		privateKey: conf.PrivateKey,
-		publicKey:  conf.PublicKey,
+		privateKey:      conf.PrivateKey,
+		publicKey:       conf.PublicKey,
+		accessTokenTTL:  conf.AccessTokenTTL,
+		refreshTokenTTL: conf.RefreshTokenTTL,
+		revoked:         revoked,
 	}
 }
 
-// ParseJWT validates and parses the given jwt access token.
-func (tm *TokenManager) ParseJWT(accessToken string) (string, error) {
-	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
+// Claims represents the claims of the access token.
+type Claims struct {
+	Role string `json:"role"`
+	jwt.StandardClaims
+}
+
+// ParseJWT validates and parses the given jwt access token and checks that it is not revoked.
+func (tm *TokenManager) ParseJWT(accessToken string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return jwt.ParseRSAPublicKeyFromPEM([]byte(tm.publicKey))
 	})
-	if err != nil || !token.Valid {
-		return "", err
+	if err != nil {
+		return nil, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", fmt.Errorf("could not get user claims from token")
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid || claims.Id == "" {
+		return nil, fmt.Errorf("could not get user claims from token")
 	}
 
-	return claims["sub"].(string), nil
+	revoked, err := tm.revoked.IsTokenRevoked(claims.Id)
+	if err != nil {
+		return nil, fmt.Errorf("can't check token revocation: %w", err)
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	return claims, nil
 }
 
-// NewJWT creates new JWT token based on user id and private key.
-func (tm *TokenManager) NewJWT(userID string) (string, error) {
-	const expTimeHrs = 24
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
-		Subject:   userID,
-		ExpiresAt: time.Now().Add(time.Hour * expTimeHrs).Unix(),
+// NewJWT creates new short-lived JWT token based on user id, user role and private key.
+func (tm *TokenManager) NewJWT(userID, role string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
+		Role: role,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Subject:   userID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(tm.accessTokenTTL).Unix(),
+		},
 	})
 
 	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(tm.privateKey))
@@ -58,3 +99,40 @@ func (tm *TokenManager) NewJWT(userID string) (string, error) {
 
 	return token.SignedString(privateKey)
 }
+
+// RevokeJWT adds the access token with the given id to the revocation list.
+func (tm *TokenManager) RevokeJWT(tokenID string) error {
+	return tm.revoked.RevokeToken(tokenID, time.Now().Add(tm.accessTokenTTL))
+}
+
+// NewRefreshToken creates new random refresh token and returns it with its expiration time.
+func (tm *TokenManager) NewRefreshToken() (string, time.Time, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("can't generate refresh token: %w", err)
+	}
+
+	return token, time.Now().Add(tm.refreshTokenTTL), nil
+}
+
+// NewAPIKey creates new random API key with a recognizable prefix.
+func NewAPIKey() (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("can't generate API key: %w", err)
+	}
+
+	return APIKeyPrefix + token, nil
+}
+
+// randomToken returns a url-safe string of random bytes.
+func randomToken() (string, error) {
+	const tokenLen = 32
+	b := make([]byte, tokenLen)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -2,6 +2,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -10,7 +12,12 @@ type Config struct {
 	PostgresData
 	JWTKeys
 	AWSData
+	StorageData
 	RabbitMQData
+	WebhookData
+	ConverterData
+	QuotaData
+	RetentionData
 }
 
 type PostgresData struct {
@@ -23,8 +30,10 @@ type PostgresData struct {
 }
 
 type JWTKeys struct {
-	PrivateKey string
-	PublicKey  string
+	PrivateKey      string
+	PublicKey       string
+	AccessTokenTTL  time.Duration `default:"15m"`
+	RefreshTokenTTL time.Duration `default:"720h"`
 }
 
 type AWSData struct {
@@ -34,9 +43,51 @@ type AWSData struct {
 	Bucket          string
 }
 
+type StorageData struct {
+	StorageType   string        `default:"s3"`
+	StoragePath   string        `default:"data"`
+	StorageURL    string        `default:"http://localhost:8000/files"`
+	StorageURLTTL time.Duration `default:"15m"`
+	WorkDir       string        `default:"/tmp/audio-converter"`
+	WorkDirMaxAge time.Duration `default:"24h"`
+	StorageSecret string
+}
+
 type RabbitMQData struct {
-	URI       string
-	QueueName string
+	URI        string
+	QueueName  string
+	MaxRetries int           `default:"3"`
+	RetryDelay time.Duration `default:"10s"`
+}
+
+type WebhookData struct {
+	WebhookSecret       string
+	WebhookAttempts     int           `default:"5"`
+	WebhookBackoff      time.Duration `default:"1s"`
+	WebhookTimeout      time.Duration `default:"10s"`
+	WebhookWorkers      int           `default:"4"`
+	WebhookPollInterval time.Duration `default:"1s"`
+}
+
+type ConverterData struct {
+	Workers         int           `default:"1"`
+	ShutdownTimeout time.Duration `default:"30s"`
+	MetricsAddr     string        `default:":8001"`
+}
+
+type QuotaData struct {
+	RequestsPerMinute int   `default:"10"`
+	MaxQueuedJobs     int   `default:"5"`
+	MaxStoredBytes    int64 `default:"1073741824"`
+	MonthlyMinutes    int   `default:"600"`
+	MaxUploadBytes    int64 `default:"104857600"`
+}
+
+type RetentionData struct {
+	FreeRetention    time.Duration `default:"720h"`
+	PremiumRetention time.Duration `default:"8760h"`
+	JanitorInterval  time.Duration `default:"1h"`
+	JanitorBatchSize int           `default:"100"`
 }
 
 // Load loads configuration parameters to Config from environment variables.
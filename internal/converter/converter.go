@@ -1,89 +1,317 @@
 package converter
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/katiasuya/audio-conversion-service/internal/format"
+	"github.com/katiasuya/audio-conversion-service/internal/logger"
+	"github.com/katiasuya/audio-conversion-service/internal/probe"
 	"github.com/katiasuya/audio-conversion-service/internal/repository"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
 	"github.com/katiasuya/audio-conversion-service/internal/storage"
+	"github.com/katiasuya/audio-conversion-service/internal/webhook"
 )
 
-var status = []string{"processing", "done", "failed"}
+// watchInterval is the interval of saving the progress of the request being converted,
+// marking it as alive and checking whether it has been cancelled.
+const watchInterval = 2 * time.Second
 
 // Converter converts audio files to other formats.
 type Converter struct {
-	repo    *repository.Repository
-	storage *storage.Storage
+	repo     *repository.Repository
+	storage  storage.Storage
+	notifier *webhook.Notifier
+	workDir  string
 }
 
 // New creates a new Converter with given fields.
-func New(repo *repository.Repository, storage *storage.Storage) *Converter {
+// The files of every conversion are kept in a separate directory inside workDir.
+func New(repo *repository.Repository, storage storage.Storage, notifier *webhook.Notifier, workDir string) *Converter {
 	return &Converter{
-		repo:    repo,
-		storage: storage,
+		repo:     repo,
+		storage:  storage,
+		notifier: notifier,
+		workDir:  workDir,
 	}
 }
 
 // Process implements audio conversion process.
-func (c *Converter) Process(fileID, filename, sourceFormat, targetFormat, requestID string) error {
-	err := c.convert(fileID, filename, sourceFormat, targetFormat, requestID)
-	if err != nil {
-		updateErr := c.repo.UpdateRequest(requestID, status[2], "")
+// If the context is cancelled, the running conversion is interrupted.
+// If the conversion is interrupted or fails with a retryable error,
+// the request is returned to the queued status, otherwise it is marked as failed.
+// A request cancelled by the user is skipped, and its running conversion is stopped.
+// A request that doesn't exist anymore is skipped too.
+func (c *Converter) Process(ctx context.Context, data model.ConversionData) error {
+	targetID, err := c.convert(ctx, data)
+	if c.skip(ctx, data, err) {
+		return nil
+	}
+	if err != nil && (ctx.Err() != nil || IsRetryable(err)) {
+		updateErr := c.repo.UpdateRequest(data.RequestID, model.StatusQueued, "")
+		if c.skip(ctx, data, updateErr) {
+			return nil
+		}
 		if updateErr != nil {
-			return fmt.Errorf("can't update request: %w", err)
+			return fmt.Errorf("can't update request: %w", updateErr)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("conversion interrupted: %w", ctx.Err())
+		}
+		return err
+	}
+	if err != nil {
+		failErr := c.Fail(data, err)
+		if c.skip(ctx, data, failErr) {
+			return nil
+		}
+		if failErr != nil {
+			return failErr
 		}
 		return err
 	}
+
+	c.notify(data, webhook.Payload{Status: model.StatusDone, TargetID: targetID})
 	return nil
 }
 
-func (c *Converter) convert(fileID, filename, sourceFormat, targetFormat, requestID string) error {
-	err := c.repo.UpdateRequest(requestID, status[0], "")
+// Fail marks the request as failed because of the given error and notifies about it.
+// If the request has been cancelled, it is left cancelled and an error wrapping
+// repository.ErrRequestCancelled is returned, if it doesn't exist, the error wraps repository.ErrNoSuchRequest.
+func (c *Converter) Fail(data model.ConversionData, err error) error {
+	code, reason := CodeInternal, "internal error"
+	var convErr *ConversionError
+	if errors.As(err, &convErr) {
+		code, reason = convErr.Code, convErr.Reason
+	}
+
+	updateErr := c.repo.FailRequest(data.RequestID, code, reason)
+	if updateErr != nil {
+		return fmt.Errorf("can't update request: %w", updateErr)
+	}
+
+	c.notify(data, webhook.Payload{Status: model.StatusFailed, ErrorCode: code, FailureReason: reason})
+	return nil
+}
+
+// skip reports whether the error shows that the request has been cancelled or doesn't exist anymore,
+// so it mustn't be processed further. It logs why the request is skipped and notifies about the cancellation.
+func (c *Converter) skip(ctx context.Context, data model.ConversionData, err error) bool {
+	switch {
+	case errors.Is(err, repository.ErrRequestCancelled):
+		logger.Info(ctx, fmt.Sprintf("request %s has been cancelled", data.RequestID))
+		c.notify(data, webhook.Payload{Status: model.StatusCancelled})
+		return true
+	case errors.Is(err, repository.ErrNoSuchRequest):
+		logger.Info(ctx, fmt.Sprintf("request %s doesn't exist", data.RequestID))
+		return true
+	default:
+		return false
+	}
+}
+
+// watchConversion periodically saves the progress of the conversion when it changes or otherwise
+// updates the request time, so that a request being converted is never considered stuck,
+// and returns a context that is cancelled when the request is cancelled by the user.
+// The returned function stops watching.
+func (c *Converter) watchConversion(ctx context.Context, requestID string, prog *progress) (context.Context, func()) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		saved := 0
+
+		for {
+			select {
+			case <-watchCtx.Done():
+				return
+			case <-ticker.C:
+				var err error
+				if percent := prog.get(); percent != saved {
+					err = c.repo.UpdateProgress(requestID, percent)
+					if err == nil {
+						saved = percent
+					}
+				} else {
+					err = c.repo.Heartbeat(requestID)
+				}
+				// Both updates skip a cancelled request, so they double as the cancellation check.
+				if errors.Is(err, repository.ErrRequestCancelled) {
+					cancel()
+					return
+				}
+				if err != nil {
+					logger.Error(ctx, fmt.Errorf("can't update request %s: %w", requestID, err))
+				}
+			}
+		}
+	}()
+
+	return watchCtx, func() {
+		cancel()
+		<-done
+	}
+}
+
+// notify queues the webhook notification about the finished conversion if the callback URL is set.
+func (c *Converter) notify(data model.ConversionData, payload webhook.Payload) {
+	if data.CallbackURL == "" {
+		return
+	}
+
+	payload.RequestID = data.RequestID
+	payload.Timestamp = time.Now().UTC()
+	err := c.notifier.Send(data.CallbackURL, payload)
 	if err != nil {
-		return fmt.Errorf("can't update request: %w", err)
+		logger.Error(context.Background(), fmt.Errorf("can't notify about request %s: %w", data.RequestID, err))
 	}
+}
 
-	err = c.storage.DownloadFileFromCloud(fileID, sourceFormat)
+func (c *Converter) convert(ctx context.Context, data model.ConversionData) (string, error) {
+	err := c.repo.UpdateRequest(data.RequestID, model.StatusProcessing, "")
 	if err != nil {
-		return err
+		return "", fmt.Errorf("can't update request: %w", err)
+	}
+
+	var prog progress
+	watchCtx, stopWatching := c.watchConversion(ctx, data.RequestID, &prog)
+	defer stopWatching()
+
+	jobDir, err := ioutil.TempDir(c.workDir, "job-")
+	if err != nil {
+		return "", fmt.Errorf("can't create job directory: %w", err)
+	}
+	defer os.RemoveAll(jobDir)
+
+	sourceLocation := filepath.Join(jobDir, "source."+data.SourceFormat)
+	targetLocation := filepath.Join(jobDir, "target."+data.TargetFormat)
+
+	err = c.storage.DownloadFile(data.FileID, data.SourceFormat, sourceLocation)
+	if err != nil {
+		return "", newConversionError(CodeDownloadFailed, "can't download source file", err)
 	}
 
 	targetFileID, err := uuid.NewRandom()
 	if err != nil {
-		return fmt.Errorf("can't generate target file uuid: %w", err)
+		return "", fmt.Errorf("can't generate target file uuid: %w", err)
 	}
 	targetFileIDStr := targetFileID.String()
 
-	sourceLocation := fmt.Sprintf(storage.LocationTmpl, fileID, sourceFormat)
-	targetLocation := fmt.Sprintf(storage.LocationTmpl, targetFileIDStr, targetFormat)
+	target, ok := format.Get(data.TargetFormat)
+	if !ok {
+		return "", newConversionError(CodeInvalidRequest, "unsupported target format",
+			fmt.Errorf("unsupported target format %q", data.TargetFormat))
+	}
+
+	duration := data.Duration
+	if duration == 0 {
+		// Requests queued before the duration was sent with the conversion data.
+		if info, err := probe.Probe(watchCtx, sourceLocation); err == nil {
+			duration = info.Duration
+		}
+	}
 
-	cmd := exec.Command("ffmpeg", "-i", sourceLocation, targetLocation)
-	err = cmd.Run()
+	var stderr bytes.Buffer
+	args := append(append([]string{}, progressArgs...), ffmpegArgs(sourceLocation, targetLocation, target, data.Params)...)
+	cmd := exec.CommandContext(watchCtx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("can't get ffmpeg output: %w", err)
+	}
+	err = cmd.Start()
+	if err == nil {
+		prog.read(stdout, duration)
+		err = cmd.Wait()
+	}
+	if watchCtx.Err() != nil && ctx.Err() == nil {
+		return "", fmt.Errorf("conversion stopped: %w", repository.ErrRequestCancelled)
+	}
 	if err != nil {
-		return fmt.Errorf("can't perform conversion")
+		return "", ffmpegError(err, stderr.String(), sourceLocation, targetLocation)
 	}
 
 	targetFile, err := os.Open(targetLocation)
 	if err != nil {
-		return fmt.Errorf("can't generate targetFileID: %w", err)
+		return "", fmt.Errorf("can't open target file: %w", err)
 	}
+	defer targetFile.Close()
 
-	err = c.storage.UploadFileToCloud(targetFile, targetFileIDStr, targetFormat)
+	targetStat, err := targetFile.Stat()
 	if err != nil {
-		return fmt.Errorf("can't upload file to s3: %w", err)
+		return "", fmt.Errorf("can't get target file size: %w", err)
 	}
 
-	targetID, err := c.repo.InsertAudio(filename, targetFormat, targetFileIDStr)
+	hasher := sha256.New()
+	err = c.storage.SaveFile(io.TeeReader(targetFile, hasher), targetFileIDStr, data.TargetFormat)
 	if err != nil {
-		return fmt.Errorf("can't insert audio: %w", err)
+		return "", newConversionError(CodeUploadFailed, "can't upload converted file", err)
 	}
 
-	err = c.repo.UpdateRequest(requestID, status[1], targetID)
+	targetProps := model.AudioProperties{
+		Size:     targetStat.Size(),
+		Checksum: hex.EncodeToString(hasher.Sum(nil)),
+	}
+	// The conversion has succeeded, so the target is stored even if its media properties can't be probed.
+	info, err := probe.Probe(ctx, targetLocation)
+	if err != nil {
+		logger.Error(ctx, fmt.Errorf("can't probe target file of request %s: %w", data.RequestID, err))
+	} else {
+		info.Apply(&targetProps)
+	}
+	// The request mustn't be updated by the watcher once it is completed.
+	stopWatching()
+	targetID, err := c.repo.CompleteRequest(data.RequestID, data.Filename, data.TargetFormat, targetFileIDStr, targetProps)
+	if errors.Is(err, repository.ErrRequestCancelled) {
+		deleteErr := c.storage.DeleteFile(targetFileIDStr, data.TargetFormat)
+		if deleteErr != nil {
+			logger.Error(ctx, fmt.Errorf("can't delete target file of cancelled request %s: %w", data.RequestID, deleteErr))
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("can't update request: %w", err)
+		return "", fmt.Errorf("can't complete request: %w", err)
 	}
 
-	return nil
+	return targetID, nil
+}
+
+// ffmpegArgs returns ffmpeg arguments to convert the source file
+// to the target format with the given encoding parameters.
+func ffmpegArgs(sourceLocation, targetLocation string, target format.Format, params model.ConversionParams) []string {
+	codec := target.Codec
+	if bitDepthCodec, ok := target.BitDepthCodecs[params.BitDepth]; ok {
+		codec = bitDepthCodec
+	}
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", sourceLocation, "-vn", "-c:a", codec}
+	if params.Bitrate != 0 {
+		args = append(args, "-b:a", strconv.Itoa(params.Bitrate)+"k")
+		args = append(args, target.CBRArgs...)
+	}
+	if params.Quality != nil && *params.Quality >= 0 && *params.Quality < len(target.Qualities) {
+		args = append(args, "-q:a", target.Qualities[*params.Quality])
+	}
+	if params.SampleRate != 0 {
+		args = append(args, "-ar", strconv.Itoa(params.SampleRate))
+	}
+	if params.Channels != 0 {
+		args = append(args, "-ac", strconv.Itoa(params.Channels))
+	}
+
+	return append(args, "-f", target.Muxer, targetLocation)
 }
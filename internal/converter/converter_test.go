@@ -0,0 +1,219 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/katiasuya/audio-conversion-service/internal/format"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// TestFfmpegArgs tests ffmpegArgs function.
+func TestFfmpegArgs(t *testing.T) {
+	quality := 2
+
+	tests := []struct {
+		name         string
+		targetFormat string
+		params       model.ConversionParams
+		exp          []string
+	}{
+		{
+			name:         "default params",
+			targetFormat: "mp3",
+			params:       model.ConversionParams{},
+			exp:          []string{"-hide_banner", "-loglevel", "error", "-y", "-i", "in", "-vn", "-c:a", "libmp3lame", "-f", "mp3", "out"},
+		},
+		{
+			name:         "constant bitrate",
+			targetFormat: "opus",
+			params:       model.ConversionParams{Bitrate: 96, SampleRate: 48000, Channels: 2},
+			exp: []string{"-hide_banner", "-loglevel", "error", "-y", "-i", "in", "-vn", "-c:a", "libopus",
+				"-b:a", "96k", "-vbr", "off", "-ar", "48000", "-ac", "2", "-f", "opus", "out"},
+		},
+		{
+			name:         "variable bitrate",
+			targetFormat: "ogg",
+			params:       model.ConversionParams{Quality: &quality},
+			exp:          []string{"-hide_banner", "-loglevel", "error", "-y", "-i", "in", "-vn", "-c:a", "libvorbis", "-q:a", "8", "-f", "ogg", "out"},
+		},
+		{
+			name:         "bit depth",
+			targetFormat: "wav",
+			params:       model.ConversionParams{BitDepth: 24, Channels: 1},
+			exp:          []string{"-hide_banner", "-loglevel", "error", "-y", "-i", "in", "-vn", "-c:a", "pcm_s24le", "-ac", "1", "-f", "wav", "out"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, _ := format.Get(tt.targetFormat)
+			res := ffmpegArgs("in", "out", target, tt.params)
+			if !reflect.DeepEqual(res, tt.exp) {
+				t.Errorf("Expected %v, got %v", tt.exp, res)
+			}
+		})
+	}
+}
+
+// TestFfmpegError tests ffmpegError function.
+func TestFfmpegError(t *testing.T) {
+	tests := []struct {
+		name      string
+		stderr    string
+		expCode   string
+		expReason string
+	}{
+		{
+			name:      "invalid input",
+			stderr:    "/tmp/in.wav: Invalid data found when processing input\n",
+			expCode:   CodeDecodeFailed,
+			expReason: "input: Invalid data found when processing input",
+		},
+		{
+			name:      "encoder error",
+			stderr:    "Error while opening encoder for output stream #0:0\n",
+			expCode:   CodeEncodeFailed,
+			expReason: "Error while opening encoder for output stream #0:0",
+		},
+		{
+			name:      "no output",
+			stderr:    "",
+			expCode:   CodeEncodeFailed,
+			expReason: "exit status 1",
+		},
+		{
+			name:      "long output",
+			stderr:    "ё" + strings.Repeat("ж", maxReasonLength/2),
+			expCode:   CodeEncodeFailed,
+			expReason: strings.Repeat("ж", maxReasonLength/2),
+		},
+		{
+			name:      "long output cut inside a rune",
+			stderr:    strings.Repeat("ж", maxReasonLength/2) + "!",
+			expCode:   CodeEncodeFailed,
+			expReason: strings.Repeat("ж", maxReasonLength/2-1) + "!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ffmpegError(errors.New("exit status 1"), tt.stderr, "/tmp/in.wav", "/tmp/out.mp3")
+			if res.Code != tt.expCode || res.Reason != tt.expReason {
+				t.Errorf("Expected %s %q, got %s %q", tt.expCode, tt.expReason, res.Code, res.Reason)
+			}
+		})
+	}
+}
+
+// TestIsRetryable tests IsRetryable function.
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		exp  bool
+	}{
+		{
+			name: "download failed",
+			err:  newConversionError(CodeDownloadFailed, "", errors.New("timeout")),
+			exp:  true,
+		},
+		{
+			name: "upload failed",
+			err:  fmt.Errorf("wrapped: %w", newConversionError(CodeUploadFailed, "", errors.New("timeout"))),
+			exp:  true,
+		},
+		{
+			name: "decode failed",
+			err:  newConversionError(CodeDecodeFailed, "", errors.New("exit status 1")),
+			exp:  false,
+		},
+		{
+			name: "encode failed",
+			err:  newConversionError(CodeEncodeFailed, "", errors.New("exit status 1")),
+			exp:  false,
+		},
+		{
+			name: "invalid request",
+			err:  newConversionError(CodeInvalidRequest, "", errors.New("unsupported target format")),
+			exp:  false,
+		},
+		{
+			name: "internal error",
+			err:  newConversionError(CodeInternal, "", errors.New("unknown")),
+			exp:  false,
+		},
+		{
+			name: "database error",
+			err:  errors.New("connection refused"),
+			exp:  true,
+		},
+		{
+			name: "no error",
+			err:  nil,
+			exp:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := IsRetryable(tt.err); res != tt.exp {
+				t.Errorf("Expected %t, got %t", tt.exp, res)
+			}
+		})
+	}
+}
+
+// TestProgressRead tests that the progress is read from the ffmpeg progress output.
+func TestProgressRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		duration float64
+		exp      int
+	}{
+		{
+			name:     "in the middle",
+			output:   "bitrate=128.0kbits/s\nout_time_us=30000000\nout_time_ms=30000000\nout_time=00:00:30.000000\nprogress=continue\n",
+			duration: 120,
+			exp:      25,
+		},
+		{
+			name:     "last line wins",
+			output:   "out_time_us=12000000\nprogress=continue\nout_time_us=60000000\nprogress=continue\n",
+			duration: 120,
+			exp:      50,
+		},
+		{
+			name:     "capped until done",
+			output:   "out_time_us=120500000\nprogress=end\n",
+			duration: 120,
+			exp:      99,
+		},
+		{
+			name:     "not available",
+			output:   "out_time_us=N/A\nprogress=continue\n",
+			duration: 120,
+			exp:      0,
+		},
+		{
+			name:     "unknown duration",
+			output:   "out_time_us=30000000\nprogress=continue\n",
+			duration: 0,
+			exp:      0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prog progress
+			prog.read(strings.NewReader(tt.output), tt.duration)
+			if got := prog.get(); got != tt.exp {
+				t.Errorf("Expected %v, got %v", tt.exp, got)
+			}
+		})
+	}
+}
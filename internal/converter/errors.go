@@ -0,0 +1,85 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error codes that categorize failed conversions.
+const (
+	CodeDownloadFailed = "download_failed"
+	CodeDecodeFailed   = "decode_failed"
+	CodeEncodeFailed   = "encode_failed"
+	CodeUploadFailed   = "upload_failed"
+	CodeInvalidRequest = "invalid_request"
+	CodeInternal       = "internal_error"
+)
+
+const maxReasonLength = 2000
+
+// ConversionError represents a failed conversion with its category
+// and a reason that can be shown to the user.
+type ConversionError struct {
+	Code   string
+	Reason string
+	Err    error
+}
+
+func newConversionError(code, reason string, err error) *ConversionError {
+	return &ConversionError{
+		Code:   code,
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+// Error returns the error message.
+func (e *ConversionError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ConversionError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable checks whether the conversion that failed with the given error may succeed if retried.
+// Of the categorized failures only storage ones are transient, the others, e.g. failures
+// of the input file decoding or invalid conversion data, are permanent.
+// Uncategorized failures, e.g. database errors, are considered transient.
+func IsRetryable(err error) bool {
+	var convErr *ConversionError
+	if errors.As(err, &convErr) {
+		return convErr.Code == CodeDownloadFailed || convErr.Code == CodeUploadFailed
+	}
+
+	return err != nil
+}
+
+// ffmpegError classifies ffmpeg failure by its stderr output
+// and hides local file locations from the reason.
+func ffmpegError(err error, stderr, sourceLocation, targetLocation string) *ConversionError {
+	code := CodeEncodeFailed
+	if strings.Contains(stderr, sourceLocation) {
+		code = CodeDecodeFailed
+	}
+
+	reason := strings.TrimSpace(stderr)
+	reason = strings.ReplaceAll(reason, sourceLocation, "input")
+	reason = strings.ReplaceAll(reason, targetLocation, "output")
+	if len(reason) > maxReasonLength {
+		// Keep the end of the output, which contains the error, cutting it on a rune boundary.
+		start := len(reason) - maxReasonLength
+		for start < len(reason) && !utf8.RuneStart(reason[start]) {
+			start++
+		}
+		reason = reason[start:]
+	}
+	if reason == "" {
+		reason = err.Error()
+	}
+
+	return newConversionError(code, reason, fmt.Errorf("can't perform conversion: %w", err))
+}
@@ -0,0 +1,66 @@
+package converter
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// progressArgs make ffmpeg write its progress to stdout as key=value lines instead of printing stats to stderr.
+var progressArgs = []string{"-progress", "pipe:1", "-nostats"}
+
+// maxRunningProgress is the highest progress reported while ffmpeg is running,
+// 100 is set only when the request is done.
+const maxRunningProgress = 99
+
+// progress holds the percent of the running conversion that is complete.
+type progress struct {
+	percent int32
+}
+
+// get returns the current percent.
+func (p *progress) get() int {
+	return int(atomic.LoadInt32(&p.percent))
+}
+
+// read reads the progress output of ffmpeg until EOF and updates the percent
+// of the source duration in seconds that has been converted.
+// Nothing is updated if the duration is unknown.
+func (p *progress) read(r io.Reader, duration float64) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		percent, ok := parseProgress(scanner.Text(), duration)
+		if ok {
+			atomic.StoreInt32(&p.percent, int32(percent))
+		}
+	}
+	// Drain the rest of the output so that ffmpeg doesn't block on a full pipe.
+	io.Copy(ioutil.Discard, r)
+}
+
+// parseProgress parses the out_time_us line of the ffmpeg progress output
+// and returns the percent of the duration in seconds it corresponds to.
+func parseProgress(line string, duration float64) (int, bool) {
+	if duration <= 0 {
+		return 0, false
+	}
+
+	value := strings.TrimPrefix(line, "out_time_us=")
+	if value == line {
+		return 0, false
+	}
+	outTime, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || outTime < 0 {
+		return 0, false
+	}
+
+	percent := int(float64(outTime) / 1e6 / duration * 100)
+	if percent > maxRunningProgress {
+		percent = maxRunningProgress
+	}
+
+	return percent, true
+}
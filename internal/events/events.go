@@ -0,0 +1,109 @@
+// Package events streams status changes of conversion requests published by the database.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/logger"
+	"github.com/lib/pq"
+)
+
+// Channel is the postgres notification channel the status changes are published to.
+const Channel = "request_status"
+
+const (
+	subscriberBuffer = 16
+	pingInterval     = 90 * time.Second
+)
+
+// Event represents a status change of a conversion request.
+type Event struct {
+	RequestID string    `json:"requestID"`
+	UserID    string    `json:"userID"`
+	Status    string    `json:"status"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Hub receives status changes from the database and fans them out to subscribers.
+type Hub struct {
+	listener *pq.Listener
+	mu       sync.Mutex
+	subs     map[chan Event]string
+}
+
+// NewHub creates a new hub receiving notifications from the given listener.
+func NewHub(listener *pq.Listener) *Hub {
+	return &Hub{
+		listener: listener,
+		subs:     make(map[chan Event]string),
+	}
+}
+
+// Run receives notifications until the context is done.
+func (h *Hub) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-h.listener.Notify:
+			// nil notification is sent after the connection is re-established.
+			if n == nil {
+				continue
+			}
+			err := h.dispatch(n.Extra)
+			if err != nil {
+				logger.Error(ctx, err)
+			}
+		case <-time.After(pingInterval):
+			err := h.listener.Ping()
+			if err != nil {
+				logger.Error(ctx, fmt.Errorf("can't ping listener connection: %w", err))
+			}
+		}
+	}
+}
+
+// Subscribe subscribes to the status changes of the given user's requests.
+// If the user id is empty, changes of all requests are received.
+// The returned function must be called to unsubscribe.
+func (h *Hub) Subscribe(userID string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	h.mu.Lock()
+	h.subs[ch] = userID
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// dispatch sends the event from the notification payload to its subscribers,
+// skipping the ones that don't keep up.
+func (h *Hub) dispatch(payload string) error {
+	var event Event
+	err := json.Unmarshal([]byte(payload), &event)
+	if err != nil {
+		return fmt.Errorf("can't decode notification: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch, userID := range h.subs {
+		if userID != "" && userID != event.UserID {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	return nil
+}
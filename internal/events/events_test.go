@@ -0,0 +1,41 @@
+package events
+
+import "testing"
+
+// TestDispatch tests that events are delivered only to subscribers of the request's user.
+func TestDispatch(t *testing.T) {
+	h := NewHub(nil)
+
+	owner, unsubscribeOwner := h.Subscribe("owner")
+	defer unsubscribeOwner()
+	other, unsubscribeOther := h.Subscribe("other")
+	defer unsubscribeOther()
+	all, unsubscribeAll := h.Subscribe("")
+	defer unsubscribeAll()
+
+	err := h.dispatch(`{"requestID":"request","userID":"owner","status":"processing","updated":"2021-04-20T10:00:00+00:00"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, ch := range map[string]<-chan Event{"owner": owner, "all": all} {
+		select {
+		case event := <-ch:
+			if event.RequestID != "request" || event.Status != "processing" {
+				t.Errorf("Unexpected event for %s subscriber: %+v", name, event)
+			}
+		default:
+			t.Errorf("Expected event for %s subscriber", name)
+		}
+	}
+
+	select {
+	case event := <-other:
+		t.Errorf("Unexpected event for other user: %+v", event)
+	default:
+	}
+
+	if err := h.dispatch("malformed"); err == nil {
+		t.Error("Expected error for malformed notification")
+	}
+}
@@ -0,0 +1,165 @@
+// Package format describes audio formats supported by the service.
+package format
+
+import (
+	"sort"
+	"strings"
+)
+
+// Format represents an audio format and the data needed to validate and convert it.
+type Format struct {
+	Name      string
+	MIMETypes []string
+	// Codec is the ffmpeg audio encoder used to produce the format.
+	Codec string
+	// Muxer is the ffmpeg output format used to produce the format.
+	Muxer string
+	// MinBitrate and MaxBitrate limit the bitrate in kbit/s, they are zero for lossless formats.
+	MinBitrate int
+	MaxBitrate int
+	// CBRArgs are additional ffmpeg arguments to make the encoder use constant bitrate.
+	CBRArgs []string
+	// Qualities maps variable bitrate quality levels from 0 (best) to 9 (worst)
+	// to the encoder's quality values, it is nil if the encoder has no quality scale.
+	Qualities []string
+	// SampleRates lists sample rates in Hz the encoder supports.
+	SampleRates []int
+	// BitDepthCodecs maps supported bit depths to the ffmpeg audio encoders producing them.
+	BitDepthCodecs map[int]string
+	// Decoders lists codec names reported by ffprobe for audio streams of the format.
+	Decoders []string
+}
+
+var (
+	lossyRates    = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}
+	losslessRates = []int{8000, 11025, 16000, 22050, 32000, 44100, 48000, 88200, 96000}
+	aacRates      = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000, 88200, 96000}
+)
+
+var formats = map[string]Format{
+	"mp3": {
+		Name:        "mp3",
+		MIMETypes:   []string{"audio/mpeg", "audio/mp3"},
+		Codec:       "libmp3lame",
+		Muxer:       "mp3",
+		MinBitrate:  8,
+		MaxBitrate:  320,
+		Qualities:   []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
+		SampleRates: lossyRates,
+		Decoders:    []string{"mp3"},
+	},
+	"wav": {
+		Name:        "wav",
+		MIMETypes:   []string{"audio/wave", "audio/wav", "audio/x-wav", "audio/vnd.wave"},
+		Codec:       "pcm_s16le",
+		Muxer:       "wav",
+		SampleRates: losslessRates,
+		BitDepthCodecs: map[int]string{
+			16: "pcm_s16le",
+			24: "pcm_s24le",
+			32: "pcm_s32le",
+		},
+		Decoders: []string{"pcm_u8", "pcm_s16le", "pcm_s24le", "pcm_s32le", "pcm_f32le", "pcm_f64le", "pcm_alaw", "pcm_mulaw"},
+	},
+	"flac": {
+		Name:        "flac",
+		MIMETypes:   []string{"audio/flac", "audio/x-flac"},
+		Codec:       "flac",
+		Muxer:       "flac",
+		SampleRates: losslessRates,
+		Decoders:    []string{"flac"},
+	},
+	"ogg": {
+		Name:        "ogg",
+		MIMETypes:   []string{"audio/ogg", "audio/vorbis", "application/ogg"},
+		Codec:       "libvorbis",
+		Muxer:       "ogg",
+		MinBitrate:  45,
+		MaxBitrate:  500,
+		Qualities:   []string{"10", "9", "8", "7", "6", "5", "4", "3", "2", "1"},
+		SampleRates: losslessRates,
+		Decoders:    []string{"vorbis"},
+	},
+	"opus": {
+		Name:        "opus",
+		MIMETypes:   []string{"audio/opus", "audio/ogg"},
+		Codec:       "libopus",
+		Muxer:       "opus",
+		MinBitrate:  6,
+		MaxBitrate:  510,
+		CBRArgs:     []string{"-vbr", "off"},
+		SampleRates: []int{8000, 12000, 16000, 24000, 48000},
+		Decoders:    []string{"opus"},
+	},
+	"m4a": {
+		Name:        "m4a",
+		MIMETypes:   []string{"audio/mp4", "audio/m4a", "audio/x-m4a"},
+		Codec:       "aac",
+		Muxer:       "ipod",
+		MinBitrate:  8,
+		MaxBitrate:  320,
+		SampleRates: aacRates,
+		Decoders:    []string{"aac", "alac"},
+	},
+	"aac": {
+		Name:        "aac",
+		MIMETypes:   []string{"audio/aac", "audio/x-aac", "audio/aacp"},
+		Codec:       "aac",
+		Muxer:       "adts",
+		MinBitrate:  8,
+		MaxBitrate:  320,
+		SampleRates: aacRates,
+		Decoders:    []string{"aac"},
+	},
+}
+
+// Get returns the format with the given name.
+func Get(name string) (Format, bool) {
+	f, ok := formats[name]
+	return f, ok
+}
+
+// HasSampleRate checks whether the format supports the given sample rate.
+func (f Format) HasSampleRate(rate int) bool {
+	for _, r := range f.SampleRates {
+		if r == rate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Names returns sorted names of all supported formats.
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// HasMIMEType checks whether the given MIME type corresponds to the format.
+func (f Format) HasMIMEType(mimeType string) bool {
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	for _, t := range f.MIMETypes {
+		if t == mimeType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasDecoder checks whether the codec reported by ffprobe corresponds to the format.
+func (f Format) HasDecoder(codec string) bool {
+	for _, d := range f.Decoders {
+		if d == codec {
+			return true
+		}
+	}
+
+	return false
+}
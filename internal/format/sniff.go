@@ -0,0 +1,37 @@
+package format
+
+import "bytes"
+
+// SniffLen is the number of bytes at the beginning of a file needed to detect its format.
+const SniffLen = 64
+
+// Detect detects the format of a file by the magic bytes at its beginning
+// and returns its name or an empty string if the format is unknown.
+func Detect(header []byte) string {
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		return "wav"
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(header, []byte("OggS")):
+		// The first page of an Ogg stream contains the identification header of its codec.
+		if bytes.Contains(header, []byte("OpusHead")) {
+			return "opus"
+		}
+		if bytes.Contains(header, []byte("\x01vorbis")) {
+			return "ogg"
+		}
+	case len(header) >= 8 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return "m4a"
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return "mp3"
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xF6 == 0xF0:
+		// ADTS frame sync with the layer bits set to zero.
+		return "aac"
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0 && header[1]&0x06 != 0:
+		// MPEG audio frame sync with a non-zero layer.
+		return "mp3"
+	}
+
+	return ""
+}
@@ -0,0 +1,72 @@
+package format
+
+import "testing"
+
+// TestDetect tests Detect function.
+func TestDetect(t *testing.T) {
+	oggPage := func(codecHeader string) []byte {
+		page := append([]byte("OggS"), make([]byte, 24)...)
+		return append(page, codecHeader...)
+	}
+
+	tests := []struct {
+		name   string
+		header []byte
+		exp    string
+	}{
+		{
+			name:   "wav",
+			header: []byte("RIFF\x24\x08\x00\x00WAVEfmt "),
+			exp:    "wav",
+		},
+		{
+			name:   "flac",
+			header: []byte("fLaC\x00\x00\x00\x22"),
+			exp:    "flac",
+		},
+		{
+			name:   "ogg vorbis",
+			header: oggPage("\x01vorbis"),
+			exp:    "ogg",
+		},
+		{
+			name:   "ogg opus",
+			header: oggPage("OpusHead"),
+			exp:    "opus",
+		},
+		{
+			name:   "m4a",
+			header: []byte("\x00\x00\x00\x20ftypM4A "),
+			exp:    "m4a",
+		},
+		{
+			name:   "mp3 with id3 tag",
+			header: []byte("ID3\x04\x00\x00"),
+			exp:    "mp3",
+		},
+		{
+			name:   "mp3 frame",
+			header: []byte{0xFF, 0xFB, 0x90, 0x64},
+			exp:    "mp3",
+		},
+		{
+			name:   "aac adts frame",
+			header: []byte{0xFF, 0xF1, 0x50, 0x80},
+			exp:    "aac",
+		},
+		{
+			name:   "unknown",
+			header: []byte("%PDF-1.7"),
+			exp:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Detect(tt.header)
+			if res != tt.exp {
+				t.Errorf("Expected %q, got %q", tt.exp, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Package janitor deletes audio files stored for longer than the retention period of their users' tier.
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/logger"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+type repository interface {
+	GetExpiredAudio(tier string, retention time.Duration, limit int) ([]model.AudioFile, error)
+	ExpireAudio(audioID string) error
+}
+
+type fileDeleter interface {
+	DeleteFile(fileID, format string) error
+}
+
+// Janitor periodically deletes expired audio files from the storage and marks their audio as expired.
+type Janitor struct {
+	repo      repository
+	storage   fileDeleter
+	retention map[string]time.Duration
+	interval  time.Duration
+	batchSize int
+}
+
+// New creates a new janitor with the retention periods of the user tiers from the configuration.
+// Audio files of a tier with zero retention period are kept forever.
+func New(conf *config.RetentionData, repo repository, storage fileDeleter) *Janitor {
+	return &Janitor{
+		repo:    repo,
+		storage: storage,
+		retention: map[string]time.Duration{
+			model.TierFree:    conf.FreeRetention,
+			model.TierPremium: conf.PremiumRetention,
+		},
+		interval:  conf.JanitorInterval,
+		batchSize: conf.JanitorBatchSize,
+	}
+}
+
+// Run cleans up expired audio files at every interval until the context is cancelled.
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := j.Clean(ctx)
+		if err != nil {
+			logger.Error(ctx, fmt.Errorf("can't clean up expired audio: %w", err))
+		}
+		if n > 0 {
+			logger.Info(ctx, fmt.Sprintf("deleted %d expired audio files", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Clean deletes the audio files that have expired and returns their number.
+// Each run deletes at most a batch of files of every tier.
+// A file that can't be deleted is left for the next run.
+func (j *Janitor) Clean(ctx context.Context) (int, error) {
+	var deleted int
+	for tier, retention := range j.retention {
+		if retention <= 0 {
+			continue
+		}
+
+		files, err := j.repo.GetExpiredAudio(tier, retention, j.batchSize)
+		if err != nil {
+			return deleted, fmt.Errorf("can't get expired audio of %s tier: %w", tier, err)
+		}
+
+		for _, file := range files {
+			if ctx.Err() != nil {
+				return deleted, nil
+			}
+
+			err = j.storage.DeleteFile(file.Location, file.Format)
+			if err != nil {
+				logger.Error(ctx, fmt.Errorf("can't delete file of audio %s: %w", file.AudioID, err))
+				continue
+			}
+
+			err = j.repo.ExpireAudio(file.AudioID)
+			if err != nil {
+				return deleted, fmt.Errorf("can't mark audio %s as expired: %w", file.AudioID, err)
+			}
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
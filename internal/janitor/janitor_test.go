@@ -0,0 +1,65 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+type fakeRepository struct {
+	files   map[string][]model.AudioFile
+	expired []string
+}
+
+func (r *fakeRepository) GetExpiredAudio(tier string, _ time.Duration, _ int) ([]model.AudioFile, error) {
+	return r.files[tier], nil
+}
+
+func (r *fakeRepository) ExpireAudio(audioID string) error {
+	r.expired = append(r.expired, audioID)
+	return nil
+}
+
+type fakeStorage struct {
+	failing string
+}
+
+func (s fakeStorage) DeleteFile(fileID, _ string) error {
+	if fileID == s.failing {
+		return errors.New("storage is unavailable")
+	}
+	return nil
+}
+
+// TestClean tests that Clean expires only the audio whose files have been deleted
+// and keeps the files of tiers without retention period.
+func TestClean(t *testing.T) {
+	repo := &fakeRepository{
+		files: map[string][]model.AudioFile{
+			model.TierFree: {
+				{AudioID: "deleted", Location: "file-1", Format: "mp3"},
+				{AudioID: "failed", Location: "file-2", Format: "wav"},
+			},
+			model.TierPremium: {
+				{AudioID: "kept", Location: "file-3", Format: "mp3"},
+			},
+		},
+	}
+	conf := &config.RetentionData{FreeRetention: time.Hour, JanitorBatchSize: 10}
+	j := New(conf, repo, fakeStorage{failing: "file-2"})
+
+	n, err := j.Clean(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected %d, got %d", 1, n)
+	}
+	if len(repo.expired) != 1 || repo.expired[0] != "deleted" {
+		t.Errorf("Expected %v, got %v", []string{"deleted"}, repo.expired)
+	}
+}
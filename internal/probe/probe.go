@@ -0,0 +1,98 @@
+// Package probe inspects audio files with ffprobe.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// Errors of files that can't be probed.
+var (
+	ErrNoAudioStream = errors.New("the file has no audio stream")
+	ErrInvalidAudio  = errors.New("the file can't be read as audio")
+)
+
+// Info represents media properties of an audio file.
+type Info struct {
+	Codec string
+	// Duration is a duration in seconds.
+	Duration   float64
+	Channels   int
+	SampleRate int
+	// Bitrate is a bitrate in bit/s.
+	Bitrate int
+}
+
+// Apply sets the probed media properties of the audio.
+func (i Info) Apply(props *model.AudioProperties) {
+	props.Codec = i.Codec
+	props.Duration = i.Duration
+	props.Channels = i.Channels
+	props.SampleRate = i.SampleRate
+	props.Bitrate = i.Bitrate
+}
+
+// Probe runs ffprobe on the file at the given location and returns the properties of its first audio stream.
+func Probe(ctx context.Context, location string) (Info, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=codec_name,channels,sample_rate,bit_rate:format=duration,bit_rate",
+		"-of", "json", location)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return Info{}, fmt.Errorf("%w: %s", ErrInvalidAudio, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if err != nil {
+		return Info{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	return parse(stdout.Bytes())
+}
+
+// parse parses json output of ffprobe.
+func parse(output []byte) (Info, error) {
+	var out struct {
+		Streams []struct {
+			CodecName  string `json:"codec_name"`
+			Channels   int    `json:"channels"`
+			SampleRate string `json:"sample_rate"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			BitRate  string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	err := json.Unmarshal(output, &out)
+	if err != nil {
+		return Info{}, fmt.Errorf("can't parse ffprobe output: %w", err)
+	}
+	if len(out.Streams) == 0 {
+		return Info{}, ErrNoAudioStream
+	}
+
+	stream := out.Streams[0]
+	info := Info{
+		Codec:    stream.CodecName,
+		Channels: stream.Channels,
+	}
+	info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+	info.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	// Streams of some containers, e.g. flac, have no bitrate, so the overall bitrate is used.
+	info.Bitrate, _ = strconv.Atoi(stream.BitRate)
+	if info.Bitrate == 0 {
+		info.Bitrate, _ = strconv.Atoi(out.Format.BitRate)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,43 @@
+package probe
+
+import "testing"
+
+// TestParse tests parsing of ffprobe output.
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		exp    Info
+		expErr error
+	}{
+		{
+			name: "mp3",
+			output: `{"programs": [], "streams": [{"codec_name": "mp3", "sample_rate": "44100", "channels": 2,
+				"bit_rate": "128000"}], "format": {"duration": "12.512000", "bit_rate": "128420"}}`,
+			exp: Info{Codec: "mp3", Duration: 12.512, Channels: 2, SampleRate: 44100, Bitrate: 128000},
+		},
+		{
+			name: "flac without stream bitrate",
+			output: `{"streams": [{"codec_name": "flac", "sample_rate": "48000", "channels": 1}],
+				"format": {"duration": "3.000000", "bit_rate": "705600"}}`,
+			exp: Info{Codec: "flac", Duration: 3, Channels: 1, SampleRate: 48000, Bitrate: 705600},
+		},
+		{
+			name:   "no audio stream",
+			output: `{"streams": [], "format": {"duration": "1.000000"}}`,
+			expErr: ErrNoAudioStream,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parse([]byte(tt.output))
+			if err != tt.expErr {
+				t.Fatalf("Expected error %v, got %v", tt.expErr, err)
+			}
+			if info != tt.exp {
+				t.Errorf("Expected %+v, got %+v", tt.exp, info)
+			}
+		})
+	}
+}
@@ -4,68 +4,255 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/katiasuya/audio-conversion-service/internal/config"
 	"github.com/katiasuya/audio-conversion-service/internal/converter"
 	"github.com/katiasuya/audio-conversion-service/internal/logger"
+	"github.com/katiasuya/audio-conversion-service/internal/repository"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
 	"github.com/streadway/amqp"
 )
 
+// attemptHeader is the message header containing the number of the processing attempt.
+const attemptHeader = "x-attempt"
+
+// errorHeader is the header of a dead-lettered message containing the last processing error.
+const errorHeader = "x-error"
+
+type channel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	QueueInspect(name string) (amqp.Queue, error)
+}
+
+type processor interface {
+	Process(ctx context.Context, data model.ConversionData) error
+	Fail(data model.ConversionData, err error) error
+}
+
 // QueueManager provides methods to use queuing fot conversion requests.
 type QueueManager struct {
-	name      string
-	ch        *amqp.Channel
-	converter *converter.Converter
+	name       string
+	maxRetries int
+	retryDelay time.Duration
+	ch         channel
+	converter  processor
 }
 
 // New creates a new queue manager.
-func New(name string, ch *amqp.Channel, converter *converter.Converter) *QueueManager {
+func New(conf *config.RabbitMQData, ch channel, converter processor) *QueueManager {
 	return &QueueManager{
-		name:      name,
-		ch:        ch,
-		converter: converter,
+		name:       conf.QueueName,
+		maxRetries: conf.MaxRetries,
+		retryDelay: conf.RetryDelay,
+		ch:         ch,
+		converter:  converter,
 	}
 }
 
-type conversionData struct {
-	FileID       string
-	Filename     string
-	SourceFormat string
-	TargetFormat string
-	RequestID    string
-}
+// ProcessMsgs processes messages coming from the queue, i.e conversion requests,
+// with a fixed number of workers until the context is done.
+// Then it stops consuming and waits for the running conversions for the shutdown timeout,
+// after which they are interrupted and their messages are returned to the queue.
+// The same happens if the delivery channel is closed, e.g. when the connection is lost,
+// and the error is returned after the running conversions have stopped.
+func (qm *QueueManager) ProcessMsgs(ctx context.Context, conf *config.ConverterData) error {
+	if conf.Workers < 1 {
+		return fmt.Errorf("invalid number of workers %d", conf.Workers)
+	}
 
-//ProcessMsgs processes messages coming from the queue, i.e conversion requests.
-func (qm *QueueManager) ProcessMsgs() error {
-	err := qm.ch.Qos(1, 0, false)
+	err := qm.ch.Qos(conf.Workers, 0, false)
 	if err != nil {
 		return fmt.Errorf("can't set QoS: %w", err)
 	}
 
-	msgs, err := qm.ch.Consume(qm.name, "", false, false, false, false, nil)
+	consumerTag := "converter-" + uuid.New().String()
+	msgs, err := qm.ch.Consume(qm.name, consumerTag, false, false, false, false, nil)
 	if err != nil {
 		return fmt.Errorf("can't register a consumer: %w", err)
 	}
 
-	for {
-		msg := <-msgs
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
 
+	jobs := make(chan amqp.Delivery)
+	var wg sync.WaitGroup
+	workersTotal.Set(int64(conf.Workers))
+	for i := 0; i < conf.Workers; i++ {
+		wg.Add(1)
 		go func() {
-			var data conversionData
-			err := json.NewDecoder(bytes.NewReader(msg.Body)).Decode(&data)
-			if err != nil {
-				logger.Error(context.Background(), fmt.Errorf("can't decode message: %w", err))
+			defer wg.Done()
+			for msg := range jobs {
+				workersBusy.Add(1)
+				qm.processMsg(jobCtx, msg)
+				workersBusy.Add(-1)
 			}
+		}()
+	}
 
-			err = qm.converter.Process(data.FileID, data.Filename, data.SourceFormat, data.TargetFormat, data.RequestID)
-			if err != nil {
-				logger.Error(context.Background(), err)
+	var consumeErr error
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case msg, ok := <-msgs:
+			if !ok {
+				consumeErr = errors.New("delivery channel is closed")
+				running = false
+				continue
 			}
 
-			err = msg.Ack(false)
-			if err != nil {
-				logger.Error(context.Background(), err)
+			select {
+			case jobs <- msg:
+			case <-ctx.Done():
+				nack(ctx, msg)
+				running = false
 			}
-		}()
+		}
+	}
+	close(jobs)
+
+	if consumeErr == nil {
+		logger.Info(ctx, "stopping to consume messages")
+		err = qm.ch.Cancel(consumerTag, false)
+		if err != nil {
+			logger.Error(ctx, fmt.Errorf("can't cancel the consumer: %w", err))
+		} else {
+			for msg := range msgs {
+				nack(ctx, msg)
+			}
+		}
+	} else {
+		logger.Error(ctx, fmt.Errorf("%w, waiting for running conversions", consumeErr))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(conf.ShutdownTimeout):
+		logger.Info(ctx, "shutdown timeout exceeded, interrupting running conversions")
+		cancelJobs()
+		<-done
+	}
+
+	return consumeErr
+}
+
+// processMsg converts the audio from the message and acknowledges it.
+// If the conversion is interrupted, the message is returned to the queue.
+// If it fails with a retryable error, the message is sent to the retry queue of its attempt,
+// when the retries are exhausted, the request is failed and the message is dead-lettered.
+func (qm *QueueManager) processMsg(ctx context.Context, msg amqp.Delivery) {
+	var data model.ConversionData
+	err := json.NewDecoder(bytes.NewReader(msg.Body)).Decode(&data)
+	if err != nil {
+		qm.deadLetter(ctx, msg, fmt.Errorf("can't decode message: %w", err))
+		return
+	}
+
+	err = qm.converter.Process(ctx, data)
+	if errors.Is(err, context.Canceled) {
+		logger.Error(ctx, err)
+		nack(ctx, msg)
+		return
+	}
+	if err == nil {
+		ack(ctx, msg)
+		return
+	}
+
+	logger.Error(ctx, fmt.Errorf("can't process request %s: %w", data.RequestID, err))
+	if !converter.IsRetryable(err) {
+		qm.deadLetter(ctx, msg, err)
+		return
+	}
+
+	attempt := msgAttempt(msg)
+	if attempt > qm.maxRetries {
+		failErr := qm.converter.Fail(data, err)
+		if errors.Is(failErr, repository.ErrRequestCancelled) || errors.Is(failErr, repository.ErrNoSuchRequest) {
+			ack(ctx, msg)
+			return
+		}
+		if failErr != nil {
+			logger.Error(ctx, failErr)
+			nack(ctx, msg)
+			return
+		}
+		qm.deadLetter(ctx, msg, err)
+		return
+	}
+
+	err = qm.ch.Publish("", retryQueue(qm.name, retryDelay(qm.retryDelay, attempt)), false, false, amqp.Publishing{
+		Headers:      amqp.Table{attemptHeader: int32(attempt + 1)},
+		DeliveryMode: amqp.Persistent,
+		ContentType:  msg.ContentType,
+		Body:         msg.Body,
+	})
+	if err != nil {
+		logger.Error(ctx, fmt.Errorf("can't schedule retry: %w", err))
+		nack(ctx, msg)
+		return
+	}
+	ack(ctx, msg)
+}
+
+// deadLetter publishes the message with the error to the dead-letter exchange for inspection.
+func (qm *QueueManager) deadLetter(ctx context.Context, msg amqp.Delivery, msgErr error) {
+	logger.Error(ctx, fmt.Errorf("dead-lettering message: %w", msgErr))
+
+	err := qm.ch.Publish(deadLetterExchange(qm.name), qm.name, false, false, amqp.Publishing{
+		Headers:      amqp.Table{attemptHeader: int32(msgAttempt(msg)), errorHeader: msgErr.Error()},
+		DeliveryMode: amqp.Persistent,
+		ContentType:  msg.ContentType,
+		Body:         msg.Body,
+	})
+	if err != nil {
+		logger.Error(ctx, fmt.Errorf("can't dead-letter message: %w", err))
+		nack(ctx, msg)
+		return
+	}
+	ack(ctx, msg)
+}
+
+// msgAttempt returns the number of the processing attempt of the message.
+func msgAttempt(msg amqp.Delivery) int {
+	switch attempt := msg.Headers[attemptHeader].(type) {
+	case int32:
+		return int(attempt)
+	case int64:
+		return int(attempt)
+	case int:
+		return attempt
+	default:
+		return 1
+	}
+}
+
+// ack acknowledges the message.
+func ack(ctx context.Context, msg amqp.Delivery) {
+	err := msg.Ack(false)
+	if err != nil {
+		logger.Error(ctx, err)
+	}
+}
+
+// nack returns the message to the queue.
+func nack(ctx context.Context, msg amqp.Delivery) {
+	err := msg.Nack(false, true)
+	if err != nil {
+		logger.Error(ctx, err)
 	}
 }
@@ -0,0 +1,15 @@
+package queue
+
+import "expvar"
+
+// Worker pool metrics published by expvar.
+var (
+	workersTotal = expvar.NewInt("converter_workers_total")
+	workersBusy  = expvar.NewInt("converter_workers_busy")
+)
+
+func init() {
+	expvar.Publish("converter_workers_idle", expvar.Func(func() interface{} {
+		return workersTotal.Value() - workersBusy.Value()
+	}))
+}
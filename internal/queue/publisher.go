@@ -4,19 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
 	"github.com/streadway/amqp"
 )
 
 //SendConversionData sends conversion request data to the queue.
-func (qm *QueueManager) SendConversionData(fileID, filename, sourceFormat, targetFormat, requestID string) error {
-	convData := conversionData{
-		FileID:       fileID,
-		Filename:     filename,
-		SourceFormat: sourceFormat,
-		TargetFormat: targetFormat,
-		RequestID:    requestID,
-	}
-
+func (qm *QueueManager) SendConversionData(convData model.ConversionData) error {
 	body, err := json.Marshal(convData)
 	if err != nil {
 		return fmt.Errorf("can't marshal the given payload: %w", err)
@@ -34,3 +27,21 @@ func (qm *QueueManager) SendConversionData(fileID, filename, sourceFormat, targe
 
 	return nil
 }
+
+// Stats returns the numbers of messages waiting in the conversion queue and in the dead-letter queue.
+func (qm *QueueManager) Stats() (model.QueueStats, error) {
+	queue, err := qm.ch.QueueInspect(qm.name)
+	if err != nil {
+		return model.QueueStats{}, fmt.Errorf("can't inspect the queue: %w", err)
+	}
+
+	deadQueue, err := qm.ch.QueueInspect(deadLetterQueue(qm.name))
+	if err != nil {
+		return model.QueueStats{}, fmt.Errorf("can't inspect the dead-letter queue: %w", err)
+	}
+
+	return model.QueueStats{
+		Queued:       queue.Messages,
+		DeadLettered: deadQueue.Messages,
+	}, nil
+}
@@ -0,0 +1,384 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+	"github.com/streadway/amqp"
+)
+
+type fakeChannel struct {
+	msgs      chan amqp.Delivery
+	qos       int
+	closeOnce sync.Once
+	published []string
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{msgs: make(chan amqp.Delivery, 10)}
+}
+
+func (ch *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	ch.qos = prefetchCount
+	return nil
+}
+
+func (ch *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return ch.msgs, nil
+}
+
+func (ch *fakeChannel) Cancel(consumer string, noWait bool) error {
+	ch.close()
+	return nil
+}
+
+func (ch *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch.published = append(ch.published, key)
+	return nil
+}
+
+func (ch *fakeChannel) QueueInspect(name string) (amqp.Queue, error) {
+	return amqp.Queue{}, nil
+}
+
+// close closes the delivery channel as the server does when the consumer is cancelled or the connection is lost.
+func (ch *fakeChannel) close() {
+	ch.closeOnce.Do(func() { close(ch.msgs) })
+}
+
+// fakeAcknowledger records whether each message was acknowledged or returned to the queue.
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	results map[uint64]string
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.set(tag, "ack")
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.set(tag, "nack")
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.set(tag, "reject")
+	return nil
+}
+
+func (a *fakeAcknowledger) set(tag uint64, result string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.results == nil {
+		a.results = make(map[uint64]string)
+	}
+	a.results[tag] = result
+}
+
+func (a *fakeAcknowledger) get(tag uint64) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.results[tag]
+}
+
+type fakeProcessor struct {
+	process func(ctx context.Context, data model.ConversionData) error
+}
+
+func (p *fakeProcessor) Process(ctx context.Context, data model.ConversionData) error {
+	return p.process(ctx, data)
+}
+
+func (p *fakeProcessor) Fail(data model.ConversionData, err error) error {
+	return nil
+}
+
+// newDelivery creates a message of the conversion request with the given delivery tag.
+func newDelivery(t *testing.T, acknowledger amqp.Acknowledger, tag uint64) amqp.Delivery {
+	body, err := json.Marshal(model.ConversionData{RequestID: fmt.Sprint(tag)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{Acknowledger: acknowledger, DeliveryTag: tag, Body: body}
+}
+
+// blockingProcessor returns a processor whose conversions report that they have started
+// and run until released or interrupted.
+func blockingProcessor(started chan<- string, release <-chan struct{}) *fakeProcessor {
+	return &fakeProcessor{process: func(ctx context.Context, data model.ConversionData) error {
+		started <- data.RequestID
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("conversion interrupted: %w", ctx.Err())
+		}
+	}}
+}
+
+// TestProcessMsgsShutdown tests that running conversions are waited for on shutdown
+// and interrupted and returned to the queue when the shutdown timeout is exceeded.
+func TestProcessMsgsShutdown(t *testing.T) {
+	tests := []struct {
+		name            string
+		shutdownTimeout time.Duration
+		release         bool
+		exp             string
+	}{
+		{
+			name:            "conversion finishes within the timeout",
+			shutdownTimeout: time.Minute,
+			release:         true,
+			exp:             "ack",
+		},
+		{
+			name:            "conversion is interrupted after the timeout",
+			shutdownTimeout: 10 * time.Millisecond,
+			release:         false,
+			exp:             "nack",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := newFakeChannel()
+			acknowledger := &fakeAcknowledger{}
+			started, release := make(chan string, 1), make(chan struct{})
+			qm := New(&config.RabbitMQData{QueueName: "conversion"}, ch, blockingProcessor(started, release))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() {
+				done <- qm.ProcessMsgs(ctx, &config.ConverterData{Workers: 1, ShutdownTimeout: tt.shutdownTimeout})
+			}()
+
+			ch.msgs <- newDelivery(t, acknowledger, 1)
+			<-started
+			cancel()
+
+			if tt.release {
+				select {
+				case err := <-done:
+					t.Fatalf("Expected to wait for the running conversion, got %v", err)
+				case <-time.After(20 * time.Millisecond):
+				}
+				close(release)
+			}
+
+			if err := <-done; err != nil {
+				t.Fatalf("Expected %v, got %v", nil, err)
+			}
+			if res := acknowledger.get(1); res != tt.exp {
+				t.Errorf("Expected %v, got %v", tt.exp, res)
+			}
+		})
+	}
+}
+
+// TestProcessMsgsNackUnstarted tests that messages which haven't been started
+// when the processing stops are returned to the queue.
+func TestProcessMsgsNackUnstarted(t *testing.T) {
+	ch := newFakeChannel()
+	acknowledger := &fakeAcknowledger{}
+	started, release := make(chan string, 1), make(chan struct{})
+	qm := New(&config.RabbitMQData{QueueName: "conversion"}, ch, blockingProcessor(started, release))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- qm.ProcessMsgs(ctx, &config.ConverterData{Workers: 1, ShutdownTimeout: time.Minute})
+	}()
+
+	for tag := uint64(1); tag <= 3; tag++ {
+		ch.msgs <- newDelivery(t, acknowledger, tag)
+	}
+	<-started
+	cancel()
+
+	// The running conversion is released when the rest are returned, so that they can't be started.
+	deadline := time.Now().Add(time.Second)
+	for (acknowledger.get(2) == "" || acknowledger.get(3) == "") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatalf("Expected %v, got %v", nil, err)
+	}
+
+	exp := map[uint64]string{1: "ack", 2: "nack", 3: "nack"}
+	for tag, result := range exp {
+		if res := acknowledger.get(tag); res != result {
+			t.Errorf("Expected %v for message %d, got %v", result, tag, res)
+		}
+	}
+}
+
+// TestProcessMsgsClosedChannel tests that the running conversions are waited for
+// and an error is returned when the delivery channel is closed.
+func TestProcessMsgsClosedChannel(t *testing.T) {
+	ch := newFakeChannel()
+	acknowledger := &fakeAcknowledger{}
+	started, release := make(chan string, 1), make(chan struct{})
+	qm := New(&config.RabbitMQData{QueueName: "conversion"}, ch, blockingProcessor(started, release))
+
+	done := make(chan error)
+	go func() {
+		done <- qm.ProcessMsgs(context.Background(), &config.ConverterData{Workers: 1, ShutdownTimeout: time.Minute})
+	}()
+
+	ch.msgs <- newDelivery(t, acknowledger, 1)
+	<-started
+	ch.close()
+	close(release)
+
+	if err := <-done; err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+	if res := acknowledger.get(1); res != "ack" {
+		t.Errorf("Expected %v, got %v", "ack", res)
+	}
+}
+
+// TestMsgAttempt tests msgAttempt function.
+func TestMsgAttempt(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		exp     int
+	}{
+		{
+			name:    "first attempt",
+			headers: nil,
+			exp:     1,
+		},
+		{
+			name:    "int32 header",
+			headers: amqp.Table{attemptHeader: int32(3)},
+			exp:     3,
+		},
+		{
+			name:    "int64 header",
+			headers: amqp.Table{attemptHeader: int64(2)},
+			exp:     2,
+		},
+		{
+			name:    "malformed header",
+			headers: amqp.Table{attemptHeader: "two"},
+			exp:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := msgAttempt(amqp.Delivery{Headers: tt.headers})
+			if res != tt.exp {
+				t.Errorf("Expected %d, got %d", tt.exp, res)
+			}
+		})
+	}
+}
+
+// TestRetryDelay tests that retryDelay doubles the delay with every attempt.
+func TestRetryDelay(t *testing.T) {
+	exp := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second}
+	for i, d := range exp {
+		if res := retryDelay(10*time.Second, i+1); res != d {
+			t.Errorf("Expected %s for attempt %d, got %s", d, i+1, res)
+		}
+	}
+}
+
+// TestProcessMsgsConcurrency tests that the prefetch count equals the number of workers
+// and that the workers convert that many messages at once.
+func TestProcessMsgsConcurrency(t *testing.T) {
+	const workers = 3
+
+	ch := newFakeChannel()
+	acknowledger := &fakeAcknowledger{}
+	started, release := make(chan string, workers), make(chan struct{})
+	qm := New(&config.RabbitMQData{QueueName: "conversion"}, ch, blockingProcessor(started, release))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- qm.ProcessMsgs(ctx, &config.ConverterData{Workers: workers, ShutdownTimeout: time.Minute})
+	}()
+
+	for tag := uint64(1); tag <= workers; tag++ {
+		ch.msgs <- newDelivery(t, acknowledger, tag)
+	}
+
+	// Every conversion blocks until released, so all of them start only if they run at once.
+	for i := 0; i < workers; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %d running conversions, got %d", workers, i)
+		}
+	}
+	close(release)
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Expected %v, got %v", nil, err)
+	}
+	if ch.qos != workers {
+		t.Errorf("Expected %v, got %v", workers, ch.qos)
+	}
+	for tag := uint64(1); tag <= workers; tag++ {
+		if res := acknowledger.get(tag); res != "ack" {
+			t.Errorf("Expected %v for message %d, got %v", "ack", tag, res)
+		}
+	}
+}
+
+// TestProcessMsgRetry tests that a message failed with a retryable error is sent to the retry queue of its delay.
+func TestProcessMsgRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int32
+		exp     string
+	}{
+		{
+			name:    "first attempt",
+			attempt: 1,
+			exp:     "conversion.retry.10000ms",
+		},
+		{
+			name:    "second attempt",
+			attempt: 2,
+			exp:     "conversion.retry.20000ms",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := newFakeChannel()
+			acknowledger := &fakeAcknowledger{}
+			processor := &fakeProcessor{process: func(ctx context.Context, data model.ConversionData) error {
+				return errors.New("storage is unavailable")
+			}}
+			qm := New(&config.RabbitMQData{QueueName: "conversion", MaxRetries: 3, RetryDelay: 10 * time.Second}, ch, processor)
+
+			msg := newDelivery(t, acknowledger, 1)
+			msg.Headers = amqp.Table{attemptHeader: tt.attempt}
+			qm.processMsg(context.Background(), msg)
+
+			if len(ch.published) != 1 || ch.published[0] != tt.exp {
+				t.Errorf("Expected %v, got %v", []string{tt.exp}, ch.published)
+			}
+			if res := acknowledger.get(1); res != "ack" {
+				t.Errorf("Expected %v, got %v", "ack", res)
+			}
+		})
+	}
+}
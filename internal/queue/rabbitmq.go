@@ -3,12 +3,14 @@ package queue
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/katiasuya/audio-conversion-service/internal/config"
 	"github.com/streadway/amqp"
 )
 
-// NewRabbitMQClient creates new rabbitmq connection.
+// NewRabbitMQClient creates new rabbitmq connection and declares the conversion queue
+// with its retry queues and the dead-letter exchange.
 func NewRabbitMQClient(conf *config.RabbitMQData) (*amqp.Connection, *amqp.Channel, error) {
 	conn, err := amqp.Dial(conf.URI)
 	if err != nil {
@@ -25,5 +27,55 @@ func NewRabbitMQClient(conf *config.RabbitMQData) (*amqp.Connection, *amqp.Chann
 		return nil, nil, fmt.Errorf("can't declare a queue: %w", err)
 	}
 
+	// Retried messages wait in a retry queue of their delay until its TTL expires
+	// and are dead-lettered back to the conversion queue.
+	for attempt := 1; attempt <= conf.MaxRetries; attempt++ {
+		delay := retryDelay(conf.RetryDelay, attempt)
+		_, err = ch.QueueDeclare(retryQueue(conf.QueueName, delay), true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": conf.QueueName,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't declare a retry queue: %w", err)
+		}
+	}
+
+	err = ch.ExchangeDeclare(deadLetterExchange(conf.QueueName), amqp.ExchangeDirect, true, false, false, false, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't declare a dead-letter exchange: %w", err)
+	}
+
+	_, err = ch.QueueDeclare(deadLetterQueue(conf.QueueName), true, false, false, false, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't declare a dead-letter queue: %w", err)
+	}
+
+	err = ch.QueueBind(deadLetterQueue(conf.QueueName), conf.QueueName, deadLetterExchange(conf.QueueName), false, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't bind a dead-letter queue: %w", err)
+	}
+
 	return conn, ch, nil
 }
+
+// retryQueue returns the name of the retry queue with the given delay.
+// The delay is a part of the name, because the TTL of a declared queue can't be changed,
+// so a changed retry delay uses new queues instead of failing to redeclare the old ones.
+func retryQueue(name string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", name, delay.Milliseconds())
+}
+
+func deadLetterExchange(name string) string {
+	return name + ".dlx"
+}
+
+func deadLetterQueue(name string) string {
+	return name + ".dead"
+}
+
+// retryDelay returns the delay before the retry after the given failed attempt,
+// it doubles with every attempt.
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	return base * time.Duration(1<<uint(attempt-1))
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// Filters of the requests listed to admins.
+const (
+	FilterFailed = "failed"
+	FilterStuck  = "stuck"
+)
+
+// ErrRequestNotReplayable is returned when a request that is neither failed nor stuck in processing is replayed.
+var ErrRequestNotReplayable = errors.New("only failed requests and requests stuck in processing can be replayed")
+
+// GetAdminRequests gets failed requests or requests stuck in queued or processing status
+// for longer than the given duration.
+func (r *Repository) GetAdminRequests(filter string, stuckFor time.Duration, limit int) ([]model.AdminRequestInfo, error) {
+	const getFailedRequests = `SELECT id, user_id, source_format, target_format, created, updated, status,
+	error_code, failure_reason
+	FROM converter.request WHERE status='failed'
+	ORDER BY updated DESC LIMIT $1;`
+	const getStuckRequests = `SELECT id, user_id, source_format, target_format, created, updated, status,
+	error_code, failure_reason
+	FROM converter.request WHERE status IN ('queued', 'processing') AND updated < NOW() - make_interval(secs => $2)
+	ORDER BY updated LIMIT $1;`
+
+	var rows *sql.Rows
+	var err error
+	if filter == FilterStuck {
+		rows, err = r.db.Query(getStuckRequests, limit, stuckFor.Seconds())
+	} else {
+		rows, err = r.db.Query(getFailedRequests, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reqs := []model.AdminRequestInfo{}
+	for rows.Next() {
+		var req model.AdminRequestInfo
+		var errorCode, failureReason sql.NullString
+		err = rows.Scan(&req.ID, &req.UserID, &req.SourceFormat, &req.TargetFormat, &req.Created, &req.Updated, &req.Status,
+			&errorCode, &failureReason)
+		if err != nil {
+			return nil, err
+		}
+		req.ErrorCode = errorCode.String
+		req.FailureReason = failureReason.String
+		reqs = append(reqs, req)
+	}
+
+	return reqs, rows.Err()
+}
+
+// conversionDataColumns are the columns of the request and its source audio scanned by scanConversionData.
+const conversionDataColumns = `a.location, a.name, r.source_format, r.target_format,
+	r.bitrate, r.quality, r.sample_rate, r.channels, r.bit_depth, r.callback_url, r.duration`
+
+// GetConversionData gets the status and the stored conversion data of the request.
+func (r *Repository) GetConversionData(requestID string) (model.ConversionData, string, error) {
+	var status string
+	const getConversionData = `SELECT r.status, ` + conversionDataColumns + `
+	FROM converter.request r JOIN converter.audio a ON a.id = r.source_id
+	WHERE r.id=$1;`
+
+	data, err := scanConversionData(r.db.QueryRow(getConversionData, requestID), requestID, &status)
+	if err == sql.ErrNoRows {
+		return model.ConversionData{}, "", ErrNoSuchRequest
+	}
+	if err != nil {
+		return model.ConversionData{}, "", err
+	}
+
+	return data, status, nil
+}
+
+// ReplayRequest returns the failed request or the request stuck in processing status
+// for longer than the given duration to the queued status, records that it was replayed
+// by the given admin and returns its conversion data to send it to the queue.
+// ErrRequestNotReplayable is returned for requests in other statuses,
+// so that a request is never converted by two workers at once,
+// and ErrAudioExpired if the source file has been deleted by the retention cleanup.
+func (r *Repository) ReplayRequest(requestID, adminID string, stuckFor time.Duration) (model.ConversionData, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.ConversionData{}, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var stuck, expired bool
+	const getRequest = `SELECT r.status, r.updated < NOW() - make_interval(secs => $2), a.expired IS NOT NULL,
+	` + conversionDataColumns + `
+	FROM converter.request r JOIN converter.audio a ON a.id = r.source_id
+	WHERE r.id=$1 FOR UPDATE OF r;`
+	data, err := scanConversionData(tx.QueryRow(getRequest, requestID, stuckFor.Seconds()), requestID, &status, &stuck, &expired)
+	if err == sql.ErrNoRows {
+		return model.ConversionData{}, ErrNoSuchRequest
+	}
+	if err != nil {
+		return model.ConversionData{}, err
+	}
+	if status != "failed" && !(status == "processing" && stuck) {
+		return model.ConversionData{}, ErrRequestNotReplayable
+	}
+	if expired {
+		return model.ConversionData{}, ErrAudioExpired
+	}
+
+	const requeueRequest = `UPDATE converter.request
+	SET status='queued', error_code=NULL, failure_reason=NULL, progress=NULL, updated=DEFAULT WHERE id=$1;`
+	_, err = tx.Exec(requeueRequest, requestID)
+	if err != nil {
+		return model.ConversionData{}, err
+	}
+
+	const insertReplayAudit = `INSERT INTO converter.replay_audit (request_id, admin_id, previous_status)
+	VALUES ($1, $2, $3);`
+	_, err = tx.Exec(insertReplayAudit, requestID, adminID, status)
+	if err != nil {
+		return model.ConversionData{}, err
+	}
+
+	return data, tx.Commit()
+}
+
+// scanConversionData scans the given leading columns and the conversion data selected with conversionDataColumns.
+func scanConversionData(row rowScanner, requestID string, dest ...interface{}) (model.ConversionData, error) {
+	var data model.ConversionData
+	var bitrate, quality, sampleRate, channels, bitDepth sql.NullInt32
+	var callbackURL sql.NullString
+	var duration sql.NullFloat64
+
+	dest = append(dest, &data.FileID, &data.Filename, &data.SourceFormat, &data.TargetFormat,
+		&bitrate, &quality, &sampleRate, &channels, &bitDepth, &callbackURL, &duration)
+	err := row.Scan(dest...)
+	if err != nil {
+		return model.ConversionData{}, err
+	}
+
+	data.RequestID = requestID
+	data.Params = model.ConversionParams{
+		Bitrate:    int(bitrate.Int32),
+		SampleRate: int(sampleRate.Int32),
+		Channels:   int(channels.Int32),
+		BitDepth:   int(bitDepth.Int32),
+	}
+	if quality.Valid {
+		q := int(quality.Int32)
+		data.Params.Quality = &q
+	}
+	data.CallbackURL = callbackURL.String
+	data.Duration = duration.Float64
+
+	return data, nil
+}
+
+// GetUsers gets the users ordered by their creation time.
+func (r *Repository) GetUsers(limit, offset int) ([]model.UserInfo, error) {
+	const getUsers = `SELECT id, username, role, tier, created FROM converter."user"
+	ORDER BY created, id LIMIT $1 OFFSET $2;`
+
+	rows, err := r.db.Query(getUsers, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []model.UserInfo{}
+	for rows.Next() {
+		var user model.UserInfo
+		err = rows.Scan(&user.ID, &user.Username, &user.Role, &user.Tier, &user.Created)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// UpdateUser sets the role and the tier of the user. Empty values are left unchanged.
+func (r *Repository) UpdateUser(userID, role, tier string) (model.UserInfo, error) {
+	var user model.UserInfo
+	const updateUser = `UPDATE converter."user"
+	SET role=COALESCE(NULLIF($2, '')::user_role, role), tier=COALESCE(NULLIF($3, '')::user_tier, tier), updated=DEFAULT
+	WHERE id=$1 RETURNING id, username, role, tier, created;`
+
+	err := r.db.QueryRow(updateUser, userID, role, tier).Scan(&user.ID, &user.Username, &user.Role, &user.Tier,
+		&user.Created)
+	if err == sql.ErrNoRows {
+		return model.UserInfo{}, ErrNoSuchUserID
+	}
+
+	return user, err
+}
+
+// GetStats counts users, audio files and conversion requests by their status.
+func (r *Repository) GetStats() (model.Stats, error) {
+	stats := model.Stats{Requests: make(map[string]int)}
+	const countUsersAndAudio = `SELECT (SELECT COUNT(*) FROM converter."user"), (SELECT COUNT(*) FROM converter.audio);`
+	err := r.db.QueryRow(countUsersAndAudio).Scan(&stats.Users, &stats.Audio)
+	if err != nil {
+		return model.Stats{}, err
+	}
+
+	const countRequests = `SELECT status, COUNT(*) FROM converter.request GROUP BY status;`
+	rows, err := r.db.Query(countRequests)
+	if err != nil {
+		return model.Stats{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return model.Stats{}, err
+		}
+		stats.Requests[status] = count
+	}
+
+	return stats, rows.Err()
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+	"github.com/lib/pq"
+)
+
+// Errors of API keys.
+var (
+	ErrNoSuchAPIKey  = errors.New("the API key with the given id does not exist")
+	ErrInvalidAPIKey = errors.New("the API key is invalid")
+)
+
+// InsertAPIKey stores the hash of the user's API key with its scopes.
+func (r *Repository) InsertAPIKey(userID, name, prefix, keyHash string, scopes []string) (model.APIKeyInfo, error) {
+	key := model.APIKeyInfo{
+		Name:   name,
+		Prefix: prefix,
+		Scopes: scopes,
+	}
+	const insertAPIKey = `INSERT INTO converter.api_key (user_id, name, prefix, key_hash, scopes)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id, created;`
+
+	err := r.db.QueryRow(insertAPIKey, userID, name, prefix, keyHash, pq.Array(scopes)).Scan(&key.ID, &key.Created)
+	return key, err
+}
+
+// GetAPIKeys gets the API keys of the user without the keys themselves.
+func (r *Repository) GetAPIKeys(userID string) ([]model.APIKeyInfo, error) {
+	const getAPIKeys = `SELECT id, name, prefix, scopes, last_used, created FROM converter.api_key
+	WHERE user_id=$1 ORDER BY created;`
+
+	rows, err := r.db.Query(getAPIKeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []model.APIKeyInfo{}
+	for rows.Next() {
+		var key model.APIKeyInfo
+		var lastUsed sql.NullTime
+		err = rows.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &lastUsed, &key.Created)
+		if err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			key.LastUsed = &lastUsed.Time
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes the user's API key by deleting it.
+func (r *Repository) DeleteAPIKey(keyID, userID string) error {
+	const deleteAPIKey = `DELETE FROM converter.api_key WHERE id=$1 AND user_id=$2;`
+
+	result, err := r.db.Exec(deleteAPIKey, keyID, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoSuchAPIKey
+	}
+
+	return nil
+}
+
+// UseAPIKey finds the API key by its hash, updates its last used time
+// and returns the id of its user and its scopes.
+func (r *Repository) UseAPIKey(keyHash string) (string, []string, error) {
+	var userID string
+	var scopes []string
+	const useAPIKey = `UPDATE converter.api_key SET last_used=NOW() WHERE key_hash=$1
+	RETURNING user_id, scopes;`
+
+	err := r.db.QueryRow(useAPIKey, keyHash).Scan(&userID, pq.Array(&scopes))
+	if err == sql.ErrNoRows {
+		return "", nil, ErrInvalidAPIKey
+	}
+
+	return userID, scopes, err
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// ErrAudioInUse is returned when the audio being deleted is a source of a queued or processing request.
+var ErrAudioInUse = errors.New("the audio with the given id is being converted")
+
+// audioColumns are the columns of the audio table scanned by scanAudio.
+const audioColumns = `a.id, a.name, a.format, a.created, a.expired, a.size, a.checksum, a.codec, a.duration,
+	a.channels, a.sample_rate, a.bitrate`
+
+// likeEscaper escapes the special characters of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetAudio gets the metadata of the audio with the given id if it belongs to the given user.
+func (r *Repository) GetAudio(id, userID string) (model.AudioMetadata, error) {
+	const getAudio = `SELECT ` + audioColumns + `
+	FROM converter.audio a
+	WHERE a.id=$1 AND EXISTS (SELECT 1 FROM converter.request r
+	WHERE (r.source_id=a.id OR r.target_id=a.id) AND r.user_id=$2);`
+
+	audio, err := scanAudio(r.db.QueryRow(getAudio, id, userID))
+	if err == sql.ErrNoRows {
+		return model.AudioMetadata{}, ErrNoSuchAudio
+	}
+
+	return audio, err
+}
+
+// GetAudioList gets the user's audio files, newest first.
+// The list is filtered by format and by a part of the name if they are not empty.
+func (r *Repository) GetAudioList(userID, format, name string, limit, offset int) ([]model.AudioMetadata, error) {
+	const getAudioList = `SELECT ` + audioColumns + `
+	FROM converter.audio a
+	WHERE EXISTS (SELECT 1 FROM converter.request r
+	WHERE (r.source_id=a.id OR r.target_id=a.id) AND r.user_id=$1)
+	AND ($2 = '' OR a.format::text = $2) AND ($3 = '' OR a.name ILIKE '%' || $3 || '%')
+	ORDER BY a.created DESC, a.id LIMIT $4 OFFSET $5;`
+
+	rows, err := r.db.Query(getAudioList, userID, format, likeEscaper.Replace(name), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audioList := []model.AudioMetadata{}
+	for rows.Next() {
+		audio, err := scanAudio(rows)
+		if err != nil {
+			return nil, err
+		}
+		audioList = append(audioList, audio)
+	}
+
+	return audioList, rows.Err()
+}
+
+// RenameAudio changes the name of the user's audio and returns its metadata.
+func (r *Repository) RenameAudio(id, userID, name string) (model.AudioMetadata, error) {
+	const renameAudio = `UPDATE converter.audio a SET name=$3, updated=DEFAULT
+	WHERE a.id=$1 AND EXISTS (SELECT 1 FROM converter.request r
+	WHERE (r.source_id=a.id OR r.target_id=a.id) AND r.user_id=$2)
+	RETURNING ` + audioColumns + `;`
+
+	audio, err := scanAudio(r.db.QueryRow(renameAudio, id, userID, name))
+	if err == sql.ErrNoRows {
+		return model.AudioMetadata{}, ErrNoSuchAudio
+	}
+
+	return audio, err
+}
+
+// DeleteAudio deletes the user's audio and returns its information to delete the file from the storage.
+// The requests of the audio are kept without the reference to it.
+// The audio can't be deleted while it is a source of a queued or processing request.
+func (r *Repository) DeleteAudio(id, userID string) (model.AudioInfo, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return model.AudioInfo{}, err
+	}
+	defer tx.Rollback()
+
+	var audio model.AudioInfo
+	var inUse bool
+	const getAudio = `SELECT a.name, a.format, a.location, EXISTS (SELECT 1 FROM converter.request r
+	WHERE r.source_id=a.id AND r.status IN ('queued', 'processing'))
+	FROM converter.audio a
+	WHERE a.id=$1 AND EXISTS (SELECT 1 FROM converter.request r
+	WHERE (r.source_id=a.id OR r.target_id=a.id) AND r.user_id=$2) FOR UPDATE;`
+	err = tx.QueryRow(getAudio, id, userID).Scan(&audio.Name, &audio.Format, &audio.Location, &inUse)
+	if err == sql.ErrNoRows {
+		return model.AudioInfo{}, ErrNoSuchAudio
+	}
+	if err != nil {
+		return model.AudioInfo{}, err
+	}
+	if inUse {
+		return model.AudioInfo{}, ErrAudioInUse
+	}
+
+	const deleteAudio = `DELETE FROM converter.audio WHERE id=$1;`
+	_, err = tx.Exec(deleteAudio, id)
+	if err != nil {
+		return model.AudioInfo{}, err
+	}
+
+	return audio, tx.Commit()
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAudio scans the audio metadata selected with audioColumns.
+func scanAudio(row rowScanner) (model.AudioMetadata, error) {
+	var audio model.AudioMetadata
+	var size, channels, sampleRate, bitrate sql.NullInt64
+	var checksum, codec sql.NullString
+	var duration sql.NullFloat64
+	var expired sql.NullTime
+
+	err := row.Scan(&audio.ID, &audio.Name, &audio.Format, &audio.Created, &expired,
+		&size, &checksum, &codec, &duration, &channels, &sampleRate, &bitrate)
+	if err != nil {
+		return model.AudioMetadata{}, err
+	}
+
+	if expired.Valid {
+		audio.Expired = &expired.Time
+	}
+
+	audio.AudioProperties = model.AudioProperties{
+		Size:       size.Int64,
+		Checksum:   checksum.String,
+		Codec:      codec.String,
+		Duration:   duration.Float64,
+		Channels:   int(channels.Int64),
+		SampleRate: int(sampleRate.Int64),
+		Bitrate:    int(bitrate.Int64),
+	}
+
+	return audio, nil
+}
@@ -3,18 +3,40 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/lib/pq"
 )
 
 // NewPostgresClient creates new postgres connection.
 func NewPostgresClient(c *config.PostgresData) (*sql.DB, error) {
-	pqInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		c.Host, c.Port, c.User, c.Password, c.DB, c.SSLMode)
-	db, err := sql.Open("postgres", pqInfo)
+	db, err := sql.Open("postgres", connInfo(c))
 	if err != nil {
 		return nil, err
 	}
 
 	return db, db.Ping()
 }
+
+// NewPostgresListener creates new listener of postgres notifications sent to the given channel.
+func NewPostgresListener(c *config.PostgresData, channel string) (*pq.Listener, error) {
+	const (
+		minReconnectInterval = 10 * time.Second
+		maxReconnectInterval = time.Minute
+	)
+
+	listener := pq.NewListener(connInfo(c), minReconnectInterval, maxReconnectInterval, nil)
+	err := listener.Listen(channel)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+func connInfo(c *config.PostgresData) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		c.Host, c.Port, c.User, c.Password, c.DB, c.SSLMode)
+}
@@ -4,6 +4,7 @@ package repository
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/katiasuya/audio-conversion-service/internal/server/model"
 	"github.com/lib/pq"
@@ -14,7 +15,12 @@ const codeUniqueViolation = "23505"
 //Errors represent database errors.
 var (
 	ErrNoSuchAudio       = errors.New("the audio with the given id does not exist")
+	ErrAudioExpired      = errors.New("the audio with the given id has expired")
+	ErrNoSuchRequest     = errors.New("the request with the given id does not exist")
+	ErrRequestCancelled  = errors.New("the request with the given id has been cancelled")
+	ErrRequestFinished   = errors.New("the request with the given id has already finished")
 	ErrNoSuchUser        = errors.New("the user with the given username does not exist")
+	ErrNoSuchUserID      = errors.New("the user with the given id does not exist")
 	ErrUserAlreadyExists = errors.New("the user with the given username already exists")
 )
 
@@ -43,89 +49,343 @@ func (r *Repository) InsertUser(username, password string) (string, error) {
 	return userID, err
 }
 
-// GetIDAndPasswordByUsername retrieves id and hashed password by the given username.
-func (r *Repository) GetIDAndPasswordByUsername(username string) (string, string, error) {
-	var userID, password string
-	const getIDAndPasswordByUsername = `SELECT id, password FROM converter."user" WHERE username=$1;`
-	err := r.db.QueryRow(getIDAndPasswordByUsername, username).Scan(&userID, &password)
+// GetCredentialsByUsername retrieves id, hashed password and role by the given username.
+func (r *Repository) GetCredentialsByUsername(username string) (string, string, string, error) {
+	var userID, password, role string
+	const getCredentialsByUsername = `SELECT id, password, role FROM converter."user" WHERE username=$1;`
+	err := r.db.QueryRow(getCredentialsByUsername, username).Scan(&userID, &password, &role)
 	if err == sql.ErrNoRows {
-		return "", "", ErrNoSuchUser
+		return "", "", "", ErrNoSuchUser
 	}
 
-	return userID, password, err
+	return userID, password, role, err
 }
 
-// InsertAudio inserts the audio into audio table.
-func (r *Repository) InsertAudio(name, format, location string) (string, error) {
-	var audioID string
-	const insertAudio = `INSERT INTO converter.audio (name, format, location) VALUES
-	($1, $2, $3) RETURNING id`
-
-	err := r.db.QueryRow(insertAudio, name, format, location).Scan(&audioID)
-	return audioID, err
-}
+// MakeRequest creates the conversion request for the uploaded file with the given properties and returns its id.
+func (r *Repository) MakeRequest(userID string, data model.ConversionData, props model.AudioProperties) (string, error) {
+	params := data.Params
+	var quality sql.NullInt32
+	if params.Quality != nil {
+		quality = sql.NullInt32{Int32: int32(*params.Quality), Valid: true}
+	}
 
-// MakeRequest creates the conversion request and returns its id.
-func (r *Repository) MakeRequest(name, sourceFormat, targetFormat, location, userID string) (string, error) {
 	var requestID string
-	const makeConversionRequest = `WITH audio_id AS (INSERT INTO converter.audio (name, format, location) VALUES
-	($1, $2, $3) RETURNING id)
-	INSERT INTO converter.request (user_id, source_id, source_format, target_id, target_format, status)
-	SELECT $4, id, $2, NULL, $5, 'queued'
+	const makeConversionRequest = `WITH audio_id AS (INSERT INTO converter.audio (name, format, location, size, checksum,
+	codec, duration, channels, sample_rate, bitrate)
+	VALUES ($1, $2, $3, $12, $13, $14, $15, $16, $17, $18) RETURNING id)
+	INSERT INTO converter.request (user_id, source_id, source_format, target_id, target_format, status,
+	bitrate, quality, sample_rate, channels, bit_depth, callback_url, duration)
+	SELECT $4, id, $2, NULL, $5, 'queued', $6, $7, $8, $9, $10, $11, $15
 	FROM audio_id RETURNING id;`
 
-	err := r.db.QueryRow(makeConversionRequest, name, sourceFormat, location, userID, targetFormat).Scan(&requestID)
+	err := r.db.QueryRow(makeConversionRequest, data.Filename, data.SourceFormat, data.FileID, userID, data.TargetFormat,
+		nullInt(params.Bitrate), quality, nullInt(params.SampleRate), nullInt(params.Channels), nullInt(params.BitDepth),
+		nullString(data.CallbackURL), props.Size, nullString(props.Checksum), nullString(props.Codec),
+		nullFloat(props.Duration), nullInt(props.Channels), nullInt(props.SampleRate), nullInt(props.Bitrate)).Scan(&requestID)
 	return requestID, err
 }
 
-// UpdateRequest updates the existing conversion request found by its id.
+// UpdateRequest updates the existing conversion request found by its id and resets its progress.
+// ErrRequestCancelled is returned if the request has been cancelled and ErrNoSuchRequest if it doesn't exist.
 func (r *Repository) UpdateRequest(requestID, status, targetID string) error {
-	var nullStr sql.NullString
-	if targetID != "" {
-		nullStr = sql.NullString{String: targetID, Valid: true}
+	const updateRequest = `UPDATE converter.request 
+	SET target_id=$2, status=$3, progress=NULL, updated=DEFAULT WHERE id=$1 AND status <> 'cancelled';`
+
+	result, err := r.db.Exec(updateRequest, requestID, nullString(targetID), status)
+	if err != nil {
+		return err
 	}
 
-	const updateRequest = `UPDATE converter.request 
-	SET target_id=$2, status=$3, updated=DEFAULT WHERE id=$1;`
+	return r.checkNotCancelled(result, requestID)
+}
+
+// UpdateProgress sets the percent of the conversion of the request that is complete.
+// ErrRequestCancelled is returned if the request has been cancelled and ErrNoSuchRequest if it doesn't exist.
+func (r *Repository) UpdateProgress(requestID string, progress int) error {
+	const updateProgress = `UPDATE converter.request
+	SET progress=$2, updated=DEFAULT WHERE id=$1 AND status <> 'cancelled';`
+
+	result, err := r.db.Exec(updateProgress, requestID, progress)
+	if err != nil {
+		return err
+	}
+
+	return r.checkNotCancelled(result, requestID)
+}
+
+// FailRequest marks the existing conversion request as failed with the given error code and reason.
+// ErrRequestCancelled is returned if the request has been cancelled and ErrNoSuchRequest if it doesn't exist.
+func (r *Repository) FailRequest(requestID, errorCode, reason string) error {
+	const failRequest = `UPDATE converter.request
+	SET status='failed', error_code=$2, failure_reason=$3, updated=DEFAULT WHERE id=$1 AND status <> 'cancelled';`
+
+	result, err := r.db.Exec(failRequest, requestID, errorCode, reason)
+	if err != nil {
+		return err
+	}
+
+	return r.checkNotCancelled(result, requestID)
+}
+
+// CompleteRequest inserts the converted audio with its properties into audio table
+// and marks the request as done with it, returning the id of the audio.
+// ErrRequestCancelled is returned and nothing is inserted if the request has been cancelled.
+func (r *Repository) CompleteRequest(requestID, name, format, location string, props model.AudioProperties) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var status string
+	const getStatus = `SELECT status FROM converter.request WHERE id=$1 FOR UPDATE;`
+	err = tx.QueryRow(getStatus, requestID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", ErrNoSuchRequest
+	}
+	if err != nil {
+		return "", err
+	}
+	if status == "cancelled" {
+		return "", ErrRequestCancelled
+	}
+
+	var audioID string
+	const insertAudio = `INSERT INTO converter.audio (name, format, location, size, checksum,
+	codec, duration, channels, sample_rate, bitrate)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`
+	err = tx.QueryRow(insertAudio, name, format, location, props.Size, nullString(props.Checksum),
+		nullString(props.Codec), nullFloat(props.Duration), nullInt(props.Channels), nullInt(props.SampleRate),
+		nullInt(props.Bitrate)).Scan(&audioID)
+	if err != nil {
+		return "", err
+	}
+
+	const completeRequest = `UPDATE converter.request SET target_id=$2, status='done', progress=100, updated=DEFAULT WHERE id=$1;`
+	_, err = tx.Exec(completeRequest, requestID, audioID)
+	if err != nil {
+		return "", err
+	}
+
+	return audioID, tx.Commit()
+}
+
+// CancelRequest marks the user's queued or processing request as cancelled.
+// ErrRequestFinished is returned if the request is already done, failed or cancelled.
+func (r *Repository) CancelRequest(requestID, userID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	const getStatus = `SELECT status FROM converter.request WHERE id=$1 AND user_id=$2 FOR UPDATE;`
+	err = tx.QueryRow(getStatus, requestID, userID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrNoSuchRequest
+	}
+	if err != nil {
+		return err
+	}
+	if status != "queued" && status != "processing" {
+		return ErrRequestFinished
+	}
+
+	const cancelRequest = `UPDATE converter.request SET status='cancelled', updated=DEFAULT WHERE id=$1;`
+	_, err = tx.Exec(cancelRequest, requestID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
-	row := r.db.QueryRow(updateRequest, requestID, nullStr, status)
-	return row.Err()
+// DeleteRequest deletes the request that couldn't be queued together with its source audio.
+func (r *Repository) DeleteRequest(requestID string) error {
+	const deleteRequest = `WITH request AS (DELETE FROM converter.request WHERE id=$1 RETURNING source_id)
+	DELETE FROM converter.audio WHERE id IN (SELECT source_id FROM request);`
+
+	_, err := r.db.Exec(deleteRequest, requestID)
+	return err
 }
 
-// GetRequestHistory gets the information about user's requests.
-func (r *Repository) GetRequestHistory(userID string) ([]model.RequestInfo, error) {
-	const getUserRequests = `SELECT r.id, a.name, r.source_format, r.target_format, r.created, r.updated, r.status
-    FROM converter.request r JOIN converter.audio a ON a.id = r.source_id
-    WHERE r.user_id=$1;`
+// Heartbeat updates the time of the request being converted, so that it isn't considered stuck.
+// ErrRequestCancelled is returned if the request has been cancelled and ErrNoSuchRequest if it doesn't exist.
+func (r *Repository) Heartbeat(requestID string) error {
+	const heartbeat = `UPDATE converter.request SET updated=DEFAULT WHERE id=$1 AND status <> 'cancelled';`
 
-	rows, err := r.db.Query(getUserRequests, userID)
+	result, err := r.db.Exec(heartbeat, requestID)
+	if err != nil {
+		return err
+	}
+
+	return r.checkNotCancelled(result, requestID)
+}
+
+// GetRequest gets the status of the user's conversion request with the given id.
+func (r *Repository) GetRequest(requestID, userID string) (model.RequestStatus, error) {
+	var req model.RequestStatus
+	var sourceID, targetID, errorCode, failureReason sql.NullString
+	var progress sql.NullInt32
+	const getRequest = `SELECT id, status, source_id, source_format, target_id, target_format, created, updated,
+	error_code, failure_reason, progress
+	FROM converter.request WHERE id=$1 AND user_id=$2;`
+
+	err := r.db.QueryRow(getRequest, requestID, userID).Scan(&req.ID, &req.Status, &sourceID, &req.SourceFormat,
+		&targetID, &req.TargetFormat, &req.Created, &req.Updated, &errorCode, &failureReason, &progress)
+	if err == sql.ErrNoRows {
+		return model.RequestStatus{}, ErrNoSuchRequest
+	}
+	req.SourceID = sourceID.String
+	req.TargetID = targetID.String
+	req.ErrorCode = errorCode.String
+	req.FailureReason = failureReason.String
+	req.Progress = int(progress.Int32)
+
+	return req, err
+}
+
+// HistoryQuery represents filters, sort order and position of a page of the request history.
+// Empty and zero fields are not applied. The status and the formats must be valid values of their types.
+type HistoryQuery struct {
+	Status       string
+	SourceFormat string
+	TargetFormat string
+	// CreatedFrom and CreatedTo limit the creation time of requests, CreatedTo is exclusive.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Ascending   bool
+	// After is the position of the last request of the previous page.
+	After *HistoryCursor
+	Limit int
+}
+
+// HistoryCursor represents the position of a request in the history ordered by creation time.
+type HistoryCursor struct {
+	Created time.Time
+	ID      string
+}
+
+// GetRequestHistory gets the page of the information about user's requests ordered by creation time.
+// The audio name is empty if the source audio has been deleted.
+func (r *Repository) GetRequestHistory(userID string, query HistoryQuery) ([]model.RequestInfo, error) {
+	const getUserRequests = `SELECT r.id, a.name, r.source_format, r.target_format, r.created, r.updated, r.status,
+    r.error_code, r.failure_reason, r.progress
+    FROM converter.request r LEFT JOIN converter.audio a ON a.id = r.source_id
+    WHERE r.user_id=$1 AND ($2::status IS NULL OR r.status = $2::status)
+    AND ($3::format IS NULL OR r.source_format = $3::format) AND ($4::format IS NULL OR r.target_format = $4::format)
+    AND ($5::timestamp IS NULL OR r.created >= $5) AND ($6::timestamp IS NULL OR r.created < $6)`
+	const getUserRequestsDesc = getUserRequests + `
+    AND ($7::timestamp IS NULL OR (r.created, r.id) < ($7, $8))
+    ORDER BY r.created DESC, r.id DESC LIMIT $9;`
+	const getUserRequestsAsc = getUserRequests + `
+    AND ($7::timestamp IS NULL OR (r.created, r.id) > ($7, $8))
+    ORDER BY r.created, r.id LIMIT $9;`
+
+	getRequests := getUserRequestsDesc
+	if query.Ascending {
+		getRequests = getUserRequestsAsc
+	}
+	var afterCreated sql.NullTime
+	var afterID sql.NullString
+	if query.After != nil {
+		afterCreated = sql.NullTime{Time: query.After.Created, Valid: true}
+		afterID = sql.NullString{String: query.After.ID, Valid: true}
+	}
+
+	rows, err := r.db.Query(getRequests, userID, nullString(query.Status), nullString(query.SourceFormat),
+		nullString(query.TargetFormat),
+		nullTime(query.CreatedFrom), nullTime(query.CreatedTo), afterCreated, afterID, query.Limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var req model.RequestInfo
-	var reqs []model.RequestInfo
+	reqs := []model.RequestInfo{}
 	for rows.Next() {
-		err = rows.Scan(&req.ID, &req.AudioName, &req.SourceFormat, &req.TargetFormat, &req.Created, &req.Updated, &req.Status)
+		var req model.RequestInfo
+		var audioName, errorCode, failureReason sql.NullString
+		var progress sql.NullInt32
+		err = rows.Scan(&req.ID, &audioName, &req.SourceFormat, &req.TargetFormat, &req.Created, &req.Updated, &req.Status,
+			&errorCode, &failureReason, &progress)
 		if err != nil {
 			return nil, err
 		}
+		req.AudioName = audioName.String
+		req.ErrorCode = errorCode.String
+		req.FailureReason = failureReason.String
+		req.Progress = int(progress.Int32)
 		reqs = append(reqs, req)
 	}
 
 	return reqs, rows.Err()
 }
 
-// GetAudioByID gets the information about the audio with the given id.
-func (r *Repository) GetAudioByID(id string) (model.AudioInfo, error) {
+// GetAudioByID gets the information about the user's audio with the given id.
+// The audio belongs to the user if it is a source or a target of one of the user's requests.
+// ErrAudioExpired is returned if the file of the audio has been deleted by the retention policy.
+func (r *Repository) GetAudioByID(id, userID string) (model.AudioInfo, error) {
 	var name, format, location string
-	const getAudioByID = `SELECT a.name, a.format, a.location FROM converter.audio  a WHERE id=$1;`
+	var expired bool
+	const getAudioByID = `SELECT a.name, a.format, a.location, a.expired IS NOT NULL FROM converter.audio a
+	WHERE a.id=$1 AND EXISTS (SELECT 1 FROM converter.request r
+	WHERE (r.source_id=a.id OR r.target_id=a.id) AND r.user_id=$2);`
 
-	err := r.db.QueryRow(getAudioByID, id).Scan(&name, &format, &location)
+	err := r.db.QueryRow(getAudioByID, id, userID).Scan(&name, &format, &location, &expired)
 	if err == sql.ErrNoRows {
 		return model.AudioInfo{}, ErrNoSuchAudio
 	}
+	if err != nil {
+		return model.AudioInfo{}, err
+	}
+	if expired {
+		return model.AudioInfo{}, ErrAudioExpired
+	}
+
+	return model.AudioInfo{Name: name, Format: format, Location: location}, nil
+}
+
+// checkNotCancelled checks that the update of the request, which skips cancelled requests, has updated it.
+// If it hasn't, ErrRequestCancelled is returned if the request exists and ErrNoSuchRequest otherwise.
+func (r *Repository) checkNotCancelled(result sql.Result, requestID string) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var exists bool
+	const requestExists = `SELECT EXISTS (SELECT 1 FROM converter.request WHERE id=$1);`
+	err = r.db.QueryRow(requestExists, requestID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoSuchRequest
+	}
+
+	return ErrRequestCancelled
+}
+
+// nullInt converts the given value to sql.NullInt32 treating zero as NULL.
+func nullInt(v int) sql.NullInt32 {
+	return sql.NullInt32{Int32: int32(v), Valid: v != 0}
+}
+
+// nullFloat converts the given value to sql.NullFloat64 treating zero as NULL.
+func nullFloat(v float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: v, Valid: v != 0}
+}
+
+// nullTime converts the given value to sql.NullTime treating zero time as NULL.
+func nullTime(v time.Time) sql.NullTime {
+	return sql.NullTime{Time: v, Valid: !v.IsZero()}
+}
 
-	return model.AudioInfo{Name: name, Format: format, Location: location}, err
+// nullString converts the given value to sql.NullString treating empty string as NULL.
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
 }
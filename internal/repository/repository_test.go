@@ -0,0 +1,566 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// TestGetAudioByID tests that GetAudioByID returns only the audio owned by the given user.
+func TestGetAudioByID(t *testing.T) {
+	const (
+		audioID = "2a4159de-9f06-4920-a9f6-6f612fd0acf5"
+		ownerID = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+		otherID = "12feccec-3974-4dc2-ac63-b4838c7bf0eb"
+	)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := New(db)
+
+	mock.ExpectQuery(`SELECT a.name, a.format, a.location, a.expired IS NOT NULL FROM converter.audio a`).
+		WithArgs(audioID, ownerID).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "format", "location", "expired"}).
+			AddRow("song", "mp3", "file-id", false))
+	mock.ExpectQuery(`SELECT a.name, a.format, a.location, a.expired IS NOT NULL FROM converter.audio a`).
+		WithArgs(audioID, otherID).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "format", "location", "expired"}))
+
+	audio, err := repo.GetAudioByID(audioID, ownerID)
+	if err != nil {
+		t.Fatalf("Expected owner to get the audio, got %v", err)
+	}
+	if audio.Location != "file-id" {
+		t.Errorf("Expected location %s, got %s", "file-id", audio.Location)
+	}
+
+	_, err = repo.GetAudioByID(audioID, otherID)
+	if err != ErrNoSuchAudio {
+		t.Errorf("Expected %v, got %v", ErrNoSuchAudio, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestRotateRefreshTokenReuse tests that presenting a used refresh token revokes its whole family.
+func TestRotateRefreshTokenReuse(t *testing.T) {
+	const (
+		userID   = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+		familyID = "9b0b5bd4-4c53-4e43-8c5c-2b0ab1c1b3c1"
+	)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := New(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT t.user_id, t.family_id, t.expires, t.used, t.revoked, u.role`).
+		WithArgs("old-hash").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "family_id", "expires", "used", "revoked", "role"}).
+			AddRow(userID, familyID, time.Now().Add(time.Hour), true, false, "user"))
+	mock.ExpectExec(`UPDATE converter.refresh_token SET revoked=TRUE WHERE family_id=\$1`).
+		WithArgs(familyID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	_, _, err = repo.RotateRefreshToken("old-hash", "new-hash", time.Now().Add(time.Hour))
+	if err != ErrRefreshTokenReused {
+		t.Errorf("Expected %v, got %v", ErrRefreshTokenReused, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestGetAudio tests that GetAudio returns the stored properties and leaves the missing ones empty.
+func TestGetAudio(t *testing.T) {
+	const (
+		audioID = "2a4159de-9f06-4920-a9f6-6f612fd0acf5"
+		ownerID = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+	)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := New(db)
+
+	created := time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT a.id, a.name, a.format, a.created, a.expired, a.size, a.checksum, a.codec, a.duration`).
+		WithArgs(audioID, ownerID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "format", "created", "expired", "size", "checksum", "codec",
+			"duration", "channels", "sample_rate", "bitrate"}).
+			AddRow(audioID, "song", "mp3", created, nil, 2048, "abc", "mp3", 12.5, 2, 44100, nil))
+
+	audio, err := repo.GetAudio(audioID, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := model.AudioMetadata{
+		ID:      audioID,
+		Name:    "song",
+		Format:  "mp3",
+		Created: created,
+		AudioProperties: model.AudioProperties{
+			Size:       2048,
+			Checksum:   "abc",
+			Codec:      "mp3",
+			Duration:   12.5,
+			Channels:   2,
+			SampleRate: 44100,
+		},
+	}
+	if audio != exp {
+		t.Errorf("Expected %+v, got %+v", exp, audio)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestDeleteAudio tests that DeleteAudio doesn't delete the audio that is being converted.
+func TestDeleteAudio(t *testing.T) {
+	const (
+		audioID = "2a4159de-9f06-4920-a9f6-6f612fd0acf5"
+		ownerID = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+	)
+
+	tests := []struct {
+		name   string
+		inUse  bool
+		expErr error
+	}{
+		{
+			name:   "not in use",
+			inUse:  false,
+			expErr: nil,
+		},
+		{
+			name:   "in use",
+			inUse:  true,
+			expErr: ErrAudioInUse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			repo := New(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT a.name, a.format, a.location, EXISTS`).
+				WithArgs(audioID, ownerID).
+				WillReturnRows(sqlmock.NewRows([]string{"name", "format", "location", "exists"}).
+					AddRow("song", "mp3", "file-id", tt.inUse))
+			if tt.inUse {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectExec(`DELETE FROM converter.audio WHERE id=\$1`).
+					WithArgs(audioID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			audio, err := repo.DeleteAudio(audioID, ownerID)
+			if err != tt.expErr {
+				t.Fatalf("Expected %v, got %v", tt.expErr, err)
+			}
+			if err == nil && audio.Location != "file-id" {
+				t.Errorf("Expected location %s, got %s", "file-id", audio.Location)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestCancelRequest tests that only queued and processing requests can be cancelled.
+func TestCancelRequest(t *testing.T) {
+	const (
+		requestID = "9b0b5bd4-4c53-4e43-8c5c-2b0ab1c1b3c1"
+		ownerID   = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+	)
+
+	tests := []struct {
+		name   string
+		status string
+		expErr error
+	}{
+		{
+			name:   "queued",
+			status: "queued",
+			expErr: nil,
+		},
+		{
+			name:   "processing",
+			status: "processing",
+			expErr: nil,
+		},
+		{
+			name:   "done",
+			status: "done",
+			expErr: ErrRequestFinished,
+		},
+		{
+			name:   "cancelled",
+			status: "cancelled",
+			expErr: ErrRequestFinished,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			repo := New(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT status FROM converter.request WHERE id=\$1 AND user_id=\$2 FOR UPDATE`).
+				WithArgs(requestID, ownerID).
+				WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(tt.status))
+			if tt.expErr == nil {
+				mock.ExpectExec(`UPDATE converter.request SET status='cancelled'`).
+					WithArgs(requestID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err = repo.CancelRequest(requestID, ownerID)
+			if err != tt.expErr {
+				t.Errorf("Expected %v, got %v", tt.expErr, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestUpdateCancelledRequest tests that the converter can't overwrite the status of a cancelled request
+// and that a missing request is told apart from a cancelled one.
+func TestUpdateCancelledRequest(t *testing.T) {
+	const requestID = "9b0b5bd4-4c53-4e43-8c5c-2b0ab1c1b3c1"
+
+	tests := []struct {
+		name   string
+		exists bool
+		expErr error
+	}{
+		{
+			name:   "cancelled",
+			exists: true,
+			expErr: ErrRequestCancelled,
+		},
+		{
+			name:   "missing",
+			exists: false,
+			expErr: ErrNoSuchRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			repo := New(db)
+
+			mock.ExpectExec(`UPDATE converter.request SET target_id=\$2, status=\$3, progress=NULL, updated=DEFAULT WHERE id=\$1 AND status <> 'cancelled'`).
+				WithArgs(requestID, nil, "processing").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM converter.request WHERE id=\$1\)`).
+				WithArgs(requestID).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.exists))
+
+			err = repo.UpdateRequest(requestID, "processing", "")
+			if err != tt.expErr {
+				t.Errorf("Expected %v, got %v", tt.expErr, err)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestHeartbeatCancelledRequest tests that the heartbeat of a cancelled request reports the cancellation.
+func TestHeartbeatCancelledRequest(t *testing.T) {
+	const requestID = "9b0b5bd4-4c53-4e43-8c5c-2b0ab1c1b3c1"
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := New(db)
+
+	mock.ExpectExec(`UPDATE converter.request SET updated=DEFAULT WHERE id=\$1 AND status <> 'cancelled'`).
+		WithArgs(requestID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM converter.request WHERE id=\$1\)`).
+		WithArgs(requestID).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	err = repo.Heartbeat(requestID)
+	if err != ErrRequestCancelled {
+		t.Errorf("Expected %v, got %v", ErrRequestCancelled, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestReplayRequest tests that only failed requests and requests stuck in processing can be replayed.
+func TestReplayRequest(t *testing.T) {
+	const (
+		requestID = "9b0b5bd4-4c53-4e43-8c5c-2b0ab1c1b3c1"
+		adminID   = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+	)
+
+	tests := []struct {
+		name    string
+		status  string
+		stuck   bool
+		expired bool
+		expErr  error
+	}{
+		{
+			name:   "failed",
+			status: "failed",
+			expErr: nil,
+		},
+		{
+			name:    "failed with expired source",
+			status:  "failed",
+			expired: true,
+			expErr:  ErrAudioExpired,
+		},
+		{
+			name:   "stuck in processing",
+			status: "processing",
+			stuck:  true,
+			expErr: nil,
+		},
+		{
+			name:   "processing",
+			status: "processing",
+			expErr: ErrRequestNotReplayable,
+		},
+		{
+			name:   "queued",
+			status: "queued",
+			stuck:  true,
+			expErr: ErrRequestNotReplayable,
+		},
+		{
+			name:   "done",
+			status: "done",
+			expErr: ErrRequestNotReplayable,
+		},
+		{
+			name:   "cancelled",
+			status: "cancelled",
+			expErr: ErrRequestNotReplayable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			repo := New(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT r.status, r.updated < NOW\(\) - make_interval\(secs => \$2\), a.expired IS NOT NULL`).
+				WithArgs(requestID, time.Hour.Seconds()).
+				WillReturnRows(sqlmock.NewRows([]string{"status", "stuck", "expired", "location", "name", "source_format",
+					"target_format", "bitrate", "quality", "sample_rate", "channels", "bit_depth", "callback_url", "duration"}).
+					AddRow(tt.status, tt.stuck, tt.expired, "file-id", "song", "wav", "mp3", 192, nil, nil, nil, nil, nil, 12.5))
+			if tt.expErr == nil {
+				mock.ExpectExec(`UPDATE converter.request SET status='queued'`).
+					WithArgs(requestID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO converter.replay_audit`).
+					WithArgs(requestID, adminID, tt.status).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			data, err := repo.ReplayRequest(requestID, adminID, time.Hour)
+			if err != tt.expErr {
+				t.Fatalf("Expected %v, got %v", tt.expErr, err)
+			}
+			if err == nil && (data.FileID != "file-id" || data.Params.Bitrate != 192 || data.RequestID != requestID) {
+				t.Errorf("Expected conversion data of the request, got %+v", data)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestEmptyLists tests that the lists returned to clients are empty rather than nil,
+// so that they are encoded as [] instead of null.
+func TestEmptyLists(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		list  func(repo *Repository) (interface{}, error)
+	}{
+		{
+			name:  "admin requests",
+			query: `SELECT id, user_id, source_format`,
+			list: func(repo *Repository) (interface{}, error) {
+				return repo.GetAdminRequests(FilterFailed, time.Hour, 10)
+			},
+		},
+		{
+			name:  "users",
+			query: `SELECT id, username, role, tier, created`,
+			list: func(repo *Repository) (interface{}, error) {
+				return repo.GetUsers(10, 0)
+			},
+		},
+		{
+			name:  "API keys",
+			query: `SELECT id, name, prefix, scopes, last_used, created`,
+			list: func(repo *Repository) (interface{}, error) {
+				return repo.GetAPIKeys("61e72557-e5af-4bc2-b19e-b1e4c7820d14")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			repo := New(db)
+
+			mock.ExpectQuery(tt.query).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			list, err := tt.list(repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := json.Marshal(list)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != "[]" {
+				t.Errorf("Expected %v, got %v", "[]", string(body))
+			}
+		})
+	}
+}
+
+// TestReserveUpload tests that the upload is recorded under the user lock only if the usage check accepts it.
+func TestReserveUpload(t *testing.T) {
+	const userID = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+	errLimit := errors.New("limit exceeded")
+
+	tests := []struct {
+		name     string
+		checkErr error
+	}{
+		{
+			name:     "accepted",
+			checkErr: nil,
+		},
+		{
+			name:     "rejected",
+			checkErr: errLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			repo := New(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT id FROM converter."user" WHERE id=\$1 FOR UPDATE`).
+				WithArgs(userID).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
+			mock.ExpectExec(`DELETE FROM converter.upload_attempt`).
+				WithArgs(userID, uploadAttemptTTL.Seconds()).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery(`SELECT \(SELECT COUNT\(\*\) FROM converter.upload_attempt`).
+				WillReturnRows(sqlmock.NewRows([]string{"per_minute", "rate_reset", "queued", "stored", "monthly"}).
+					AddRow(2, 30.0, 1, 1024, 600.0))
+			if tt.checkErr == nil {
+				mock.ExpectQuery(`INSERT INTO converter.upload_attempt`).
+					WithArgs(userID).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("attempt-id"))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			var checked model.Usage
+			id, err := repo.ReserveUpload(userID, time.Now(), func(usage model.Usage, rateReset time.Duration) error {
+				checked = usage
+				return tt.checkErr
+			})
+			if err != tt.checkErr {
+				t.Fatalf("Expected %v, got %v", tt.checkErr, err)
+			}
+			if tt.checkErr == nil && id != "attempt-id" {
+				t.Errorf("Expected %v, got %v", "attempt-id", id)
+			}
+			exp := model.Usage{RequestsPerMinute: 2, QueuedJobs: 1, StoredBytes: 1024, MonthlyMinutes: 10}
+			if checked != exp {
+				t.Errorf("Expected %+v, got %+v", exp, checked)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// GetExpiredAudio gets the files of the audio of the users of the given tier
+// stored for longer than the retention period, oldest first.
+// The sources of queued and processing requests are skipped.
+func (r *Repository) GetExpiredAudio(tier string, retention time.Duration, limit int) ([]model.AudioFile, error) {
+	const getExpiredAudio = `SELECT a.id, a.location, a.format FROM converter.audio a
+	WHERE a.expired IS NULL AND a.created < NOW() - make_interval(secs => $2)
+	AND EXISTS (SELECT 1 FROM converter.request r JOIN converter."user" u ON u.id = r.user_id
+	WHERE (r.source_id=a.id OR r.target_id=a.id) AND u.tier=$1)
+	AND NOT EXISTS (SELECT 1 FROM converter.request r
+	WHERE r.source_id=a.id AND r.status IN ('queued', 'processing'))
+	ORDER BY a.created LIMIT $3;`
+
+	rows, err := r.db.Query(getExpiredAudio, tier, retention.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []model.AudioFile
+	for rows.Next() {
+		var file model.AudioFile
+		err = rows.Scan(&file.AudioID, &file.Location, &file.Format)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+// ExpireAudio marks the audio as expired after its file has been deleted from the storage.
+func (r *Repository) ExpireAudio(audioID string) error {
+	const expireAudio = `UPDATE converter.audio SET expired=NOW(), updated=DEFAULT
+	WHERE id=$1 AND expired IS NULL;`
+
+	_, err := r.db.Exec(expireAudio, audioID)
+	return err
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Errors of refresh tokens.
+var (
+	ErrInvalidRefreshToken = errors.New("the refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("the refresh token has already been used")
+)
+
+// InsertRefreshToken stores the hash of the user's refresh token in the given token family.
+func (r *Repository) InsertRefreshToken(userID, familyID, tokenHash string, expires time.Time) error {
+	const insertRefreshToken = `INSERT INTO converter.refresh_token (user_id, family_id, token_hash, expires)
+	VALUES ($1, $2, $3, $4);`
+
+	_, err := r.db.Exec(insertRefreshToken, userID, familyID, tokenHash, expires)
+	return err
+}
+
+// RotateRefreshToken marks the refresh token as used and replaces it with the new one
+// in the same family, returning the id and the role of its user.
+// When a used token is presented again, the whole family is revoked.
+func (r *Repository) RotateRefreshToken(tokenHash, newTokenHash string, expires time.Time) (string, string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var userID, familyID, role string
+	var tokenExpires time.Time
+	var used, revoked bool
+	const getRefreshToken = `SELECT t.user_id, t.family_id, t.expires, t.used, t.revoked, u.role
+	FROM converter.refresh_token t JOIN converter."user" u ON u.id = t.user_id
+	WHERE t.token_hash=$1 FOR UPDATE OF t;`
+	err = tx.QueryRow(getRefreshToken, tokenHash).Scan(&userID, &familyID, &tokenExpires, &used, &revoked, &role)
+	if err == sql.ErrNoRows {
+		return "", "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if revoked || tokenExpires.Before(time.Now()) {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	if used {
+		const revokeFamily = `UPDATE converter.refresh_token SET revoked=TRUE WHERE family_id=$1;`
+		_, err = tx.Exec(revokeFamily, familyID)
+		if err != nil {
+			return "", "", err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+
+	const markUsed = `UPDATE converter.refresh_token SET used=TRUE WHERE token_hash=$1;`
+	_, err = tx.Exec(markUsed, tokenHash)
+	if err != nil {
+		return "", "", err
+	}
+
+	const insertRefreshToken = `INSERT INTO converter.refresh_token (user_id, family_id, token_hash, expires)
+	VALUES ($1, $2, $3, $4);`
+	_, err = tx.Exec(insertRefreshToken, userID, familyID, newTokenHash, expires)
+	if err != nil {
+		return "", "", err
+	}
+
+	return userID, role, tx.Commit()
+}
+
+// RevokeRefreshTokenFamily revokes all refresh tokens of the family the user's token belongs to.
+func (r *Repository) RevokeRefreshTokenFamily(tokenHash, userID string) error {
+	const revokeFamily = `UPDATE converter.refresh_token SET revoked=TRUE
+	WHERE family_id=(SELECT family_id FROM converter.refresh_token WHERE token_hash=$1 AND user_id=$2);`
+
+	result, err := r.db.Exec(revokeFamily, tokenHash, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidRefreshToken
+	}
+
+	return nil
+}
+
+// RevokeToken adds the access token id to the revocation list
+// and removes the ids of the tokens that have already expired.
+func (r *Repository) RevokeToken(tokenID string, expires time.Time) error {
+	const insertRevokedToken = `INSERT INTO converter.revoked_token (jti, expires) VALUES ($1, $2)
+	ON CONFLICT (jti) DO NOTHING;`
+	_, err := r.db.Exec(insertRevokedToken, tokenID, expires)
+	if err != nil {
+		return err
+	}
+
+	const deleteExpiredTokens = `DELETE FROM converter.revoked_token WHERE expires < NOW();`
+	_, err = r.db.Exec(deleteExpiredTokens)
+	return err
+}
+
+// IsTokenRevoked checks whether the access token id is in the revocation list.
+func (r *Repository) IsTokenRevoked(tokenID string) (bool, error) {
+	var revoked bool
+	const isTokenRevoked = `SELECT EXISTS (SELECT 1 FROM converter.revoked_token WHERE jti=$1);`
+
+	err := r.db.QueryRow(isTokenRevoked, tokenID).Scan(&revoked)
+	return revoked, err
+}
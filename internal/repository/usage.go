@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// uploadAttemptTTL is the time after which an unfinished upload attempt is considered abandoned,
+// e.g. because the API stopped during the upload, and stops counting.
+const uploadAttemptTTL = time.Hour
+
+// queryRower is implemented by *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// GetUsage gets the resources used by the user: uploads started during the last minute,
+// requests waiting or being converted including the uploads in progress, bytes of stored audio
+// and minutes of audio converted since the start of the month. It also returns the time after which
+// the oldest upload of the last minute stops counting.
+func (r *Repository) GetUsage(userID string, monthStart time.Time) (model.Usage, time.Duration, error) {
+	return getUsage(r.db, userID, monthStart)
+}
+
+func getUsage(db queryRower, userID string, monthStart time.Time) (model.Usage, time.Duration, error) {
+	var usage model.Usage
+	var rateReset sql.NullFloat64
+	var monthlySeconds float64
+	const getUsage = `SELECT
+	(SELECT COUNT(*) FROM converter.upload_attempt WHERE user_id=$1 AND created > NOW() - INTERVAL '1 minute'),
+	(SELECT EXTRACT(EPOCH FROM MIN(created) + INTERVAL '1 minute' - NOW())
+		FROM converter.upload_attempt WHERE user_id=$1 AND created > NOW() - INTERVAL '1 minute'),
+	(SELECT COUNT(*) FROM converter.request WHERE user_id=$1 AND status IN ('queued', 'processing')) +
+	(SELECT COUNT(*) FROM converter.upload_attempt
+		WHERE user_id=$1 AND finished IS NULL AND created > NOW() - make_interval(secs => $3)),
+	(SELECT COALESCE(SUM(a.size), 0) FROM converter.audio a WHERE a.expired IS NULL AND EXISTS (SELECT 1 FROM converter.request r
+		WHERE r.user_id=$1 AND (r.source_id = a.id OR r.target_id = a.id))),
+	(SELECT COALESCE(SUM(duration), 0) FROM converter.request
+		WHERE user_id=$1 AND status NOT IN ('failed', 'cancelled') AND created >= $2);`
+
+	err := db.QueryRow(getUsage, userID, monthStart, uploadAttemptTTL.Seconds()).Scan(&usage.RequestsPerMinute, &rateReset,
+		&usage.QueuedJobs, &usage.StoredBytes, &monthlySeconds)
+	if err != nil {
+		return model.Usage{}, 0, err
+	}
+	usage.MonthlyMinutes = monthlySeconds / 60
+
+	return usage, time.Duration(rateReset.Float64 * float64(time.Second)), nil
+}
+
+// ReserveUpload checks the usage of the user with the given function and records the upload attempt
+// if it is accepted, returning the id of the attempt. The user is locked while the usage is checked,
+// so that concurrent uploads of the user can't exceed the limits together.
+// The attempt counts towards the uploads per minute even if the upload fails,
+// and towards the requests waiting or being converted until it is finished.
+func (r *Repository) ReserveUpload(userID string, monthStart time.Time,
+	check func(usage model.Usage, rateReset time.Duration) error) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var id string
+	const lockUser = `SELECT id FROM converter."user" WHERE id=$1 FOR UPDATE;`
+	err = tx.QueryRow(lockUser, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", ErrNoSuchUserID
+	}
+	if err != nil {
+		return "", err
+	}
+
+	const deleteOldAttempts = `DELETE FROM converter.upload_attempt
+	WHERE user_id=$1 AND created < NOW() - make_interval(secs => $2);`
+	_, err = tx.Exec(deleteOldAttempts, userID, uploadAttemptTTL.Seconds())
+	if err != nil {
+		return "", err
+	}
+
+	usage, rateReset, err := getUsage(tx, userID, monthStart)
+	if err != nil {
+		return "", err
+	}
+	err = check(usage, rateReset)
+	if err != nil {
+		return "", err
+	}
+
+	var attemptID string
+	const insertAttempt = `INSERT INTO converter.upload_attempt (user_id) VALUES ($1) RETURNING id;`
+	err = tx.QueryRow(insertAttempt, userID).Scan(&attemptID)
+	if err != nil {
+		return "", err
+	}
+
+	return attemptID, tx.Commit()
+}
+
+// FinishUpload marks the upload attempt as finished, so that it stops counting as a request waiting to be converted.
+func (r *Repository) FinishUpload(attemptID string) error {
+	const finishAttempt = `UPDATE converter.upload_attempt SET finished=NOW() WHERE id=$1;`
+
+	_, err := r.db.Exec(finishAttempt, attemptID)
+	return err
+}
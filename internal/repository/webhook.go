@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// InsertWebhookDelivery stores the webhook notification of the conversion request to be delivered.
+func (r *Repository) InsertWebhookDelivery(requestID, url string, payload []byte) error {
+	const insertWebhookDelivery = `INSERT INTO converter.webhook_delivery (request_id, url, payload)
+	VALUES ($1, $2, $3);`
+
+	_, err := r.db.Exec(insertWebhookDelivery, requestID, url, payload)
+	return err
+}
+
+// ClaimWebhookDeliveries gets at most limit pending deliveries that are due and postpones
+// their next attempt by the lease, so that other notifiers don't send them at the same time.
+// If the notifier stops before recording the attempt, the delivery is sent again when the lease ends.
+func (r *Repository) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	const claimWebhookDeliveries = `UPDATE converter.webhook_delivery
+	SET next_attempt = NOW() + make_interval(secs => $2), updated=DEFAULT
+	WHERE id IN (SELECT id FROM converter.webhook_delivery
+	WHERE status='pending' AND next_attempt <= NOW()
+	ORDER BY next_attempt LIMIT $1 FOR UPDATE SKIP LOCKED)
+	RETURNING id, request_id, url, payload, attempt;`
+
+	rows, err := r.db.Query(claimWebhookDeliveries, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		var d model.WebhookDelivery
+		err = rows.Scan(&d.ID, &d.RequestID, &d.URL, &d.Payload, &d.Attempt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// RecordWebhookAttempt records the result of an attempt to send the delivery and sets its status.
+// A pending delivery is attempted again after the given delay.
+func (r *Repository) RecordWebhookAttempt(id, status string, statusCode int, deliveryErr string, delay time.Duration) error {
+	const recordWebhookAttempt = `UPDATE converter.webhook_delivery
+	SET attempt=attempt+1, status=$2, status_code=$3, error=$4, next_attempt = NOW() + make_interval(secs => $5),
+	updated=DEFAULT WHERE id=$1;`
+
+	_, err := r.db.Exec(recordWebhookAttempt, id, status, nullInt(statusCode), nullString(deliveryErr), delay.Seconds())
+	return err
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/katiasuya/audio-conversion-service/internal/appcontext"
+	"github.com/katiasuya/audio-conversion-service/internal/repository"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+	res "github.com/katiasuya/audio-conversion-service/internal/server/response"
+)
+
+const (
+	defaultAdminLimit = 100
+	maxAdminLimit     = 1000
+	defaultStuckFor   = time.Hour
+	// minStuckFor is far longer than the interval at which the converter updates the requests it converts,
+	// so a request being converted is never considered stuck and converted twice at once.
+	minStuckFor = 5 * time.Minute
+)
+
+// AdminRequests lists failed or stuck conversion requests of all users.
+func (s *Server) AdminRequests(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := query.Get("filter")
+	if filter == "" {
+		filter = repository.FilterFailed
+	}
+	if filter != repository.FilterFailed && filter != repository.FilterStuck {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid filter, need %s or %s",
+			repository.FilterFailed, repository.FilterStuck))
+		return
+	}
+
+	stuckFor, err := parseStuckFor(query)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	limit := defaultAdminLimit
+	if value := query.Get("limit"); value != "" {
+		l, err := strconv.Atoi(value)
+		if err != nil || l <= 0 || l > maxAdminLimit {
+			res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("limit must be from 1 to %d", maxAdminLimit))
+			return
+		}
+		limit = l
+	}
+
+	resp, err := s.repo.GetAdminRequests(filter, stuckFor, limit)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get requests", err, http.StatusInternalServerError)
+		return
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// AdminRequest shows the status and the stored conversion data of a request.
+func (s *Server) AdminRequest(w http.ResponseWriter, r *http.Request) {
+	requestID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(requestID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't get request: %w", repository.ErrNoSuchRequest))
+		return
+	}
+
+	data, status, err := s.repo.GetConversionData(requestID)
+	if err == repository.ErrNoSuchRequest {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't get request: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get request", err, http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Status         string               `json:"status"`
+		ConversionData model.ConversionData `json:"conversionData"`
+	}
+
+	res.Respond(w, http.StatusOK, response{Status: status, ConversionData: data})
+}
+
+// ReplayRequest sends a failed request or a request stuck in processing to the conversion queue again.
+func (s *Server) ReplayRequest(w http.ResponseWriter, r *http.Request) {
+	requestID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(requestID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't replay request: %w", repository.ErrNoSuchRequest))
+		return
+	}
+
+	stuckFor, err := parseStuckFor(r.URL.Query())
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	adminID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := s.repo.ReplayRequest(requestID, adminID, stuckFor)
+	if err == repository.ErrNoSuchRequest {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't replay request: %w", err))
+		return
+	}
+	if err == repository.ErrRequestNotReplayable {
+		res.RespondErr(w, http.StatusConflict, fmt.Errorf("can't replay request: %w", err))
+		return
+	}
+	if err == repository.ErrAudioExpired {
+		res.RespondErr(w, http.StatusGone, fmt.Errorf("can't replay request: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't replay request", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = s.queueMgr.SendConversionData(data)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't send data to queue", err, http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		ID string `json:"id"`
+	}
+
+	res.Respond(w, http.StatusAccepted, response{ID: requestID})
+}
+
+// Users lists the users with their roles.
+func (s *Server) Users(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r.URL.Query(), defaultAdminLimit, maxAdminLimit)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp, err := s.repo.GetUsers(limit, offset)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get users", err, http.StatusInternalServerError)
+		return
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// UpdateUser changes the role or the tier of a user.
+func (s *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Role string
+		Tier string
+	}
+
+	userID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(userID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't update user: %w", repository.ErrNoSuchUserID))
+		return
+	}
+
+	var req request
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("can't decode request body: %w", err))
+		return
+	}
+	defer r.Body.Close()
+
+	if req.Role == "" && req.Tier == "" {
+		res.RespondErr(w, http.StatusBadRequest, errors.New("role or tier is missing"))
+		return
+	}
+	if req.Role != "" && req.Role != model.RoleUser && req.Role != model.RoleAdmin {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid role, need %s or %s", model.RoleUser, model.RoleAdmin))
+		return
+	}
+	if req.Tier != "" && req.Tier != model.TierFree && req.Tier != model.TierPremium {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid tier, need %s or %s", model.TierFree, model.TierPremium))
+		return
+	}
+
+	resp, err := s.repo.UpdateUser(userID, req.Role, req.Tier)
+	if err == repository.ErrNoSuchUserID {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't update user: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't update user", err, http.StatusInternalServerError)
+		return
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// Stats shows the numbers of users, audio files and conversion requests
+// and the state of the conversion queues.
+func (s *Server) Stats(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.repo.GetStats()
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get stats", err, http.StatusInternalServerError)
+		return
+	}
+
+	resp.Queue, err = s.queueMgr.Stats()
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get queue stats", err, http.StatusInternalServerError)
+		return
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// parseStuckFor parses the duration a request must stay queued or processing to be considered stuck.
+func parseStuckFor(query url.Values) (time.Duration, error) {
+	value := query.Get("stuckFor")
+	if value == "" {
+		return defaultStuckFor, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < minStuckFor {
+		return 0, fmt.Errorf("stuckFor must be a duration of at least %s, e.g. 30m", minStuckFor)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestParseStuckFor tests that stuckFor can't be shorter than the minimum.
+func TestParseStuckFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  url.Values
+		exp    time.Duration
+		expErr bool
+	}{
+		{
+			name:  "default",
+			query: url.Values{},
+			exp:   defaultStuckFor,
+		},
+		{
+			name:  "minimum",
+			query: url.Values{"stuckFor": {"5m"}},
+			exp:   minStuckFor,
+		},
+		{
+			name:   "shorter than minimum",
+			query:  url.Values{"stuckFor": {"10s"}},
+			expErr: true,
+		},
+		{
+			name:   "malformed",
+			query:  url.Values{"stuckFor": {"an hour"}},
+			expErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := parseStuckFor(tt.query)
+			if (err != nil) != tt.expErr {
+				t.Fatalf("Expected error %v, got %v", tt.expErr, err)
+			}
+			if res != tt.exp {
+				t.Errorf("Expected %v, got %v", tt.exp, res)
+			}
+		})
+	}
+}
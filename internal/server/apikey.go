@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/katiasuya/audio-conversion-service/internal/appcontext"
+	"github.com/katiasuya/audio-conversion-service/internal/auth"
+	"github.com/katiasuya/audio-conversion-service/internal/repository"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+	res "github.com/katiasuya/audio-conversion-service/internal/server/response"
+	"github.com/katiasuya/audio-conversion-service/pkg/hash"
+)
+
+// apiKeyHeader is the request header containing an API key.
+const apiKeyHeader = "X-API-Key"
+
+// keyPrefixLen is the length of the beginning of the key stored to recognize it in the list.
+const keyPrefixLen = 8
+
+// authorizeAPIKey authorizes the request by the API key, checking that the key has the scope
+// needed for the request method. Requests authorized by API keys never get the admin role.
+func (s *Server) authorizeAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, apiKey string) {
+	userID, scopes, err := s.repo.UseAPIKey(hash.TokenHash(apiKey))
+	if err == repository.ErrInvalidAPIKey {
+		res.RespondErr(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't check API key", err, http.StatusInternalServerError)
+		return
+	}
+
+	scope := requiredScope(r.Method)
+	if !hasScope(scopes, scope) {
+		res.RespondErr(w, http.StatusForbidden, fmt.Errorf("the API key has no %s scope", scope))
+		return
+	}
+
+	ctx := appcontext.AddUserID(r.Context(), userID)
+	ctx = appcontext.AddRole(ctx, model.RoleUser)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// RequireJWT is a middleware that allows only requests authorized by a user's access token, not by an API key.
+func (s *Server) RequireJWT(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := appcontext.GetTokenID(r.Context()); !ok {
+			res.RespondErr(w, http.StatusForbidden, errors.New("access token required, API keys are not allowed"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CreateAPIKey creates new API key of a user. The key is shown only in this response.
+func (s *Server) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Name   string
+		Scopes []string
+	}
+
+	var req request
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("can't decode request body: %w", err))
+		return
+	}
+	defer r.Body.Close()
+
+	err = ValidateAPIKey(req.Name, req.Scopes)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid API key: %w", err))
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	apiKey, err := auth.NewAPIKey()
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't create API key", err, http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := s.repo.InsertAPIKey(userID, req.Name, apiKey[:keyPrefixLen], hash.TokenHash(apiKey), req.Scopes)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't insert API key", err, http.StatusInternalServerError)
+		return
+	}
+	resp.Key = apiKey
+
+	res.Respond(w, http.StatusCreated, resp)
+}
+
+// APIKeys lists API keys of a user.
+func (s *Server) APIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := s.repo.GetAPIKeys(userID)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get API keys", err, http.StatusInternalServerError)
+		return
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// DeleteAPIKey revokes an API key of a user.
+func (s *Server) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(keyID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't delete API key: %w", repository.ErrNoSuchAPIKey))
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	err := s.repo.DeleteAPIKey(keyID, userID)
+	if err == repository.ErrNoSuchAPIKey {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't delete API key: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't delete API key", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requiredScope returns the API key scope needed for the request method.
+func requiredScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return model.ScopeRead
+	default:
+		return model.ScopeWrite
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/katiasuya/audio-conversion-service/internal/appcontext"
+	"github.com/katiasuya/audio-conversion-service/internal/format"
+	"github.com/katiasuya/audio-conversion-service/internal/logger"
+	"github.com/katiasuya/audio-conversion-service/internal/repository"
+	res "github.com/katiasuya/audio-conversion-service/internal/server/response"
+)
+
+const (
+	defaultAudioLimit = 100
+	maxAudioLimit     = 1000
+)
+
+// AudioList lists the audio files of a user, optionally filtered by format and name.
+func (s *Server) AudioList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	audioFormat := strings.ToLower(query.Get("format"))
+	if _, ok := format.Get(audioFormat); audioFormat != "" && !ok {
+		res.RespondErr(w, http.StatusBadRequest, errInvalidFormat)
+		return
+	}
+
+	limit, offset, err := parsePagination(query, defaultAudioLimit, maxAudioLimit)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := s.repo.GetAudioList(userID, audioFormat, query.Get("name"), limit, offset)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get audio list", err, http.StatusInternalServerError)
+		return
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// Audio shows the metadata of a user's audio file.
+func (s *Server) Audio(w http.ResponseWriter, r *http.Request) {
+	audioID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(audioID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't get audio: %w", repository.ErrNoSuchAudio))
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := s.repo.GetAudio(audioID, userID)
+	if err == repository.ErrNoSuchAudio {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't get audio: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get audio", err, http.StatusInternalServerError)
+		return
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// RenameAudio changes the name of a user's audio file.
+func (s *Server) RenameAudio(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Name string
+	}
+
+	audioID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(audioID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't rename audio: %w", repository.ErrNoSuchAudio))
+		return
+	}
+
+	var req request
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("can't decode request body: %w", err))
+		return
+	}
+	defer r.Body.Close()
+
+	err = ValidateAudioName(req.Name)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid name: %w", err))
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := s.repo.RenameAudio(audioID, userID, req.Name)
+	if err == repository.ErrNoSuchAudio {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't rename audio: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't rename audio", err, http.StatusInternalServerError)
+		return
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// DeleteAudio deletes a user's audio file from the database and the storage.
+// The conversion requests of the audio stay in the history.
+func (s *Server) DeleteAudio(w http.ResponseWriter, r *http.Request) {
+	audioID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(audioID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't delete audio: %w", repository.ErrNoSuchAudio))
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	audioInfo, err := s.repo.DeleteAudio(audioID, userID)
+	if err == repository.ErrNoSuchAudio {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't delete audio: %w", err))
+		return
+	}
+	if err == repository.ErrAudioInUse {
+		res.RespondErr(w, http.StatusConflict, fmt.Errorf("can't delete audio: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't delete audio", err, http.StatusInternalServerError)
+		return
+	}
+
+	// The audio is already deleted from the database, so the request succeeds
+	// even if the file is left in the storage.
+	err = s.storage.DeleteFile(audioInfo.Location, audioInfo.Format)
+	if err != nil {
+		logger.Error(r.Context(), fmt.Errorf("can't delete file of audio %s: %w", audioID, err))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -6,16 +6,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/katiasuya/audio-conversion-service/internal/appcontext"
 	"github.com/katiasuya/audio-conversion-service/internal/auth"
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/events"
 	"github.com/katiasuya/audio-conversion-service/internal/queue"
 
 	"github.com/katiasuya/audio-conversion-service/internal/logger"
 	"github.com/katiasuya/audio-conversion-service/internal/repository"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
 	res "github.com/katiasuya/audio-conversion-service/internal/server/response"
 	"github.com/katiasuya/audio-conversion-service/internal/storage"
 	"github.com/katiasuya/audio-conversion-service/pkg/hash"
@@ -24,24 +33,40 @@ import (
 // Server represents application server.
 type Server struct {
 	repo     *repository.Repository
-	storage  *storage.Storage
+	uploads  uploadReserver
+	storage  storage.Storage
 	tokenMgr *auth.TokenManager
 	queueMgr *queue.QueueManager
+	hub      *events.Hub
+	quotas   config.QuotaData
+	workDir  string
 }
 
 // New creates new application server.
-func New(repo *repository.Repository, storage *storage.Storage, tokenMgr *auth.TokenManager, queueMgr *queue.QueueManager) *Server {
+// Uploaded files are spooled to workDir before they are stored.
+func New(repo *repository.Repository, storage storage.Storage, tokenMgr *auth.TokenManager,
+	queueMgr *queue.QueueManager, hub *events.Hub, quotas config.QuotaData, workDir string) *Server {
 	return &Server{
 		repo:     repo,
+		uploads:  repo,
 		storage:  storage,
 		tokenMgr: tokenMgr,
 		queueMgr: queueMgr,
+		hub:      hub,
+		quotas:   quotas,
+		workDir:  workDir,
 	}
 }
 
-// IsAuthorized is a middleware that checks user authorization.
+// IsAuthorized is a middleware that checks user authorization
+// by the bearer access token or by the API key.
 func (s *Server) IsAuthorized(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" {
+			s.authorizeAPIKey(w, r, next, apiKey)
+			return
+		}
+
 		authHeader := strings.Split(r.Header.Get("Authorization"), "Bearer ")
 		if len(authHeader) != 2 {
 			logAndRespondErr(r.Context(), w, "", errors.New("malformed token"), http.StatusUnauthorized)
@@ -49,17 +74,34 @@ func (s *Server) IsAuthorized(next http.Handler) http.Handler {
 		}
 
 		jwtToken := authHeader[1]
-		claimUserID, err := s.tokenMgr.ParseJWT(jwtToken)
+		claims, err := s.tokenMgr.ParseJWT(jwtToken)
 		if err != nil {
 			logAndRespondErr(r.Context(), w, "can't parse JWT", err, http.StatusUnauthorized)
 			return
 		}
 
-		ctx := appcontext.AddUserID(r.Context(), claimUserID)
+		ctx := appcontext.AddUserID(r.Context(), claims.Subject)
+		ctx = appcontext.AddRole(ctx, claims.Role)
+		ctx = appcontext.AddTokenID(ctx, claims.Id)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequireRole returns a middleware that allows only users with the given role.
+func (s *Server) RequireRole(role string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userRole, ok := appcontext.GetRole(r.Context())
+			if !ok || userRole != role {
+				res.RespondErr(w, http.StatusForbidden, fmt.Errorf("%s role required", role))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // AddLogger creates logger and adds it to the context.
 func (s *Server) AddLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -73,13 +115,36 @@ func (s *Server) RegisterRoutes(r *mux.Router) {
 	r.Use(s.AddLogger)
 	api := r.NewRoute().Subrouter()
 	api.Use(s.IsAuthorized)
+	session := api.NewRoute().Subrouter()
+	session.Use(s.RequireJWT)
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Use(s.RequireRole(model.RoleAdmin))
 
 	r.HandleFunc("/signup", s.SignUp).Methods("POST")
 	r.HandleFunc("/login", s.LogIn).Methods("POST")
+	r.HandleFunc("/token/refresh", s.RefreshToken).Methods("POST")
+	session.HandleFunc("/logout", s.LogOut).Methods("POST")
+	session.HandleFunc("/api_keys", s.CreateAPIKey).Methods("POST")
+	session.HandleFunc("/api_keys", s.APIKeys).Methods("GET")
+	session.HandleFunc("/api_keys/{id}", s.DeleteAPIKey).Methods("DELETE")
 	api.HandleFunc("/docs", s.ShowDoc).Methods("GET")
-	api.HandleFunc("/conversion", s.ConversionRequest).Methods("POST")
+	api.Handle("/conversion", s.CheckQuota(http.HandlerFunc(s.ConversionRequest))).Methods("POST")
+	api.HandleFunc("/conversion/events", s.ConversionEvents).Methods("GET")
+	api.HandleFunc("/conversion/{id}", s.ConversionStatus).Methods("GET")
+	api.HandleFunc("/conversion/{id}", s.CancelConversion).Methods("DELETE")
 	api.HandleFunc("/request_history", s.RequestHistory).Methods("GET")
+	api.HandleFunc("/usage", s.Usage).Methods("GET")
+	api.HandleFunc("/audio", s.AudioList).Methods("GET")
+	api.HandleFunc("/audio/{id}", s.Audio).Methods("GET")
+	api.HandleFunc("/audio/{id}", s.RenameAudio).Methods("PATCH")
+	api.HandleFunc("/audio/{id}", s.DeleteAudio).Methods("DELETE")
 	api.HandleFunc("/download_audio/{id}", s.Download).Methods("GET")
+	admin.HandleFunc("/requests", s.AdminRequests).Methods("GET")
+	admin.HandleFunc("/requests/{id}", s.AdminRequest).Methods("GET")
+	admin.HandleFunc("/requests/{id}/replay", s.ReplayRequest).Methods("POST")
+	admin.HandleFunc("/users", s.Users).Methods("GET")
+	admin.HandleFunc("/users/{id}", s.UpdateUser).Methods("PATCH")
+	admin.HandleFunc("/stats", s.Stats).Methods("GET")
 }
 
 // ShowDoc shows service documentation.
@@ -134,15 +199,18 @@ func (s *Server) SignUp(w http.ResponseWriter, r *http.Request) {
 	res.Respond(w, http.StatusCreated, resp)
 }
 
+// tokenResponse represents the tokens issued to a user.
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+}
+
 // LogIn implements user's logging in.
 func (s *Server) LogIn(w http.ResponseWriter, r *http.Request) {
 	type request struct {
 		Username string
 		Password string
 	}
-	type response struct {
-		Token string `json:"token"`
-	}
 
 	var req request
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -152,7 +220,7 @@ func (s *Server) LogIn(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	userID, hashedPwd, err := s.repo.GetIDAndPasswordByUsername(req.Username)
+	userID, hashedPwd, role, err := s.repo.GetCredentialsByUsername(req.Username)
 	if err == repository.ErrNoSuchUser {
 		res.RespondErr(w, http.StatusUnauthorized, fmt.Errorf("can't get user id and password: %w", err))
 		return
@@ -168,42 +236,187 @@ func (s *Server) LogIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jwtToken, err := s.tokenMgr.NewJWT(userID)
+	jwtToken, err := s.tokenMgr.NewJWT(userID, role)
 	if err != nil {
 		logAndRespondErr(r.Context(), w, "can't create JWT", err, http.StatusUnauthorized)
 		return
 	}
 
-	resp := response{
-		Token: jwtToken,
+	refreshToken, expires, err := s.tokenMgr.NewRefreshToken()
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't create refresh token", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = s.repo.InsertRefreshToken(userID, uuid.NewString(), hash.TokenHash(refreshToken), expires)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't insert refresh token", err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := tokenResponse{
+		Token:        jwtToken,
+		RefreshToken: refreshToken,
 	}
 
 	res.Respond(w, http.StatusCreated, resp)
 }
 
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token.
+func (s *Server) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		RefreshToken string
+	}
+
+	var req request
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		res.RespondErr(w, http.StatusUnauthorized, errors.New("can't get refresh token from request body"))
+		return
+	}
+	defer r.Body.Close()
+
+	refreshToken, expires, err := s.tokenMgr.NewRefreshToken()
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't create refresh token", err, http.StatusInternalServerError)
+		return
+	}
+
+	userID, role, err := s.repo.RotateRefreshToken(hash.TokenHash(req.RefreshToken), hash.TokenHash(refreshToken), expires)
+	if err == repository.ErrInvalidRefreshToken || err == repository.ErrRefreshTokenReused {
+		res.RespondErr(w, http.StatusUnauthorized, fmt.Errorf("can't refresh token: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't refresh token", err, http.StatusInternalServerError)
+		return
+	}
+
+	jwtToken, err := s.tokenMgr.NewJWT(userID, role)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't create JWT", err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := tokenResponse{
+		Token:        jwtToken,
+		RefreshToken: refreshToken,
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// LogOut revokes the refresh token family of the user and the current access token.
+func (s *Server) LogOut(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		RefreshToken string
+	}
+
+	var req request
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		res.RespondErr(w, http.StatusBadRequest, errors.New("can't get refresh token from request body"))
+		return
+	}
+	defer r.Body.Close()
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+	tokenID, ok := appcontext.GetTokenID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get token id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	err = s.repo.RevokeRefreshTokenFamily(hash.TokenHash(req.RefreshToken), userID)
+	if err == repository.ErrInvalidRefreshToken {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("can't revoke refresh token: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't revoke refresh token", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = s.tokenMgr.RevokeJWT(tokenID)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't revoke JWT", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // ConversionRequest creates a request for audio conversion.
+// The file is streamed to a temporary file without buffering the form, so it must be the last part of the form.
+// Its content is checked to match the source format before it is uploaded to the storage.
 func (s *Server) ConversionRequest(w http.ResponseWriter, r *http.Request) {
-	sourceFile, header, err := r.FormFile("file")
+	maxUploadBytes := s.quotas.MaxUploadBytes
+	if maxUploadBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes+maxFormFieldsBytes)
+	}
+
+	form, filePart, err := readConversionForm(r)
 	if err != nil {
 		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("can't get file from the form: %w", err))
 		return
 	}
-	defer sourceFile.Close()
+	defer filePart.Close()
 
-	sourceContentType := header.Header.Values("Content-type")
-	sourceFormat := strings.ToLower(r.FormValue("sourceFormat"))
-	targetFormat := strings.ToLower(r.FormValue("targetFormat"))
-	filename := strings.TrimSuffix(header.Filename, "."+sourceFormat)
+	sourceContentType := filePart.Header.Get("Content-Type")
+	sourceFormat := strings.ToLower(form.Get("sourceFormat"))
+	targetFormat := strings.ToLower(form.Get("targetFormat"))
+	filename := strings.TrimSuffix(filePart.FileName(), "."+sourceFormat)
 
-	err = ValidateRequest(filename, sourceFormat, targetFormat, sourceContentType[0])
+	err = ValidateRequest(filename, sourceFormat, targetFormat, sourceContentType)
 	if err != nil {
 		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
 		return
 	}
 
-	fileID, err := s.storage.UploadFile(sourceFile, sourceFormat)
+	params, err := parseConversionParams(form)
 	if err != nil {
-		logAndRespondErr(r.Context(), w, "can't upload file", err, http.StatusInternalServerError)
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	err = ValidateParams(targetFormat, params)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid conversion parameters: %w", err))
+		return
+	}
+
+	callbackURL := form.Get("callbackURL")
+	err = ValidateCallbackURL(r.Context(), callbackURL)
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid callback URL: %w", err))
+		return
+	}
+
+	source := newUploadReader(filePart, maxUploadBytes)
+	spool, err := spoolUpload(source, s.workDir)
+	if source.tooLarge() {
+		res.RespondErr(w, http.StatusRequestEntityTooLarge,
+			fmt.Errorf("can't upload file: %w, the limit is %d bytes", errFileTooLarge, maxUploadBytes))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't receive file", err, http.StatusBadRequest)
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	info, err := inspectUpload(r.Context(), spool, sourceFormat)
+	if err == errContentMismatch || err == errInvalidAudio {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid file: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't inspect file", err, http.StatusInternalServerError)
 		return
 	}
 
@@ -213,15 +426,48 @@ func (s *Server) ConversionRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	requestID, err := s.repo.MakeRequest(filename, sourceFormat, targetFormat, fileID, userID)
+	// CheckQuota can't know the duration of the upload, so the monthly minutes are checked again with it.
+	if s.quotas.MonthlyMinutes > 0 {
+		now := time.Now()
+		usage, _, err := s.repo.GetUsage(userID, monthStart(now))
+		if err != nil {
+			logAndRespondErr(r.Context(), w, "can't get usage", err, http.StatusInternalServerError)
+			return
+		}
+		retryAfter, err := checkMonthlyMinutes(s.quotas, usage, info.Duration, now)
+		if err != nil {
+			respondQuotaErr(w, retryAfter, err)
+			return
+		}
+	}
+
+	_, err = spool.Seek(0, io.SeekStart)
 	if err != nil {
-		logAndRespondErr(r.Context(), w, "can't make conversion request", err, http.StatusInternalServerError)
+		logAndRespondErr(r.Context(), w, "can't read file", err, http.StatusInternalServerError)
 		return
 	}
 
-	err = s.queueMgr.SendConversionData(fileID, filename, sourceFormat, targetFormat, requestID)
+	fileID, err := s.storage.UploadFile(spool, sourceFormat)
 	if err != nil {
-		logAndRespondErr(r.Context(), w, "can't send data to queue", err, http.StatusInternalServerError)
+		logAndRespondErr(r.Context(), w, "can't upload file", err, http.StatusInternalServerError)
+		return
+	}
+
+	sourceProps := source.properties()
+	info.Apply(&sourceProps)
+
+	convData := model.ConversionData{
+		FileID:       fileID,
+		Filename:     filename,
+		SourceFormat: sourceFormat,
+		TargetFormat: targetFormat,
+		Params:       params,
+		CallbackURL:  callbackURL,
+		Duration:     sourceProps.Duration,
+	}
+	requestID, err := s.queueRequest(r.Context(), userID, convData, sourceProps)
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't queue conversion request", err, http.StatusInternalServerError)
 		return
 	}
 
@@ -235,33 +481,200 @@ func (s *Server) ConversionRequest(w http.ResponseWriter, r *http.Request) {
 	res.Respond(w, http.StatusAccepted, convertResp)
 }
 
-// RequestHistory shows request history of a user.
+// queueRequest makes the conversion request for the uploaded file and sends it to the queue.
+// If it fails, the request and the uploaded file are deleted, so that they aren't left without
+// a conversion that would ever use them.
+func (s *Server) queueRequest(ctx context.Context, userID string, data model.ConversionData,
+	props model.AudioProperties) (string, error) {
+	requestID, err := s.repo.MakeRequest(userID, data, props)
+	if err != nil {
+		s.discardUpload(ctx, data)
+		return "", fmt.Errorf("can't make conversion request: %w", err)
+	}
+
+	data.RequestID = requestID
+	err = s.queueMgr.SendConversionData(data)
+	if err != nil {
+		// The file is deleted only with the request, so that no request refers to a deleted file.
+		deleteErr := s.repo.DeleteRequest(requestID)
+		if deleteErr != nil {
+			logger.Error(ctx, fmt.Errorf("can't delete request %s that wasn't queued: %w", requestID, deleteErr))
+		} else {
+			s.discardUpload(ctx, data)
+		}
+		return "", fmt.Errorf("can't send data to queue: %w", err)
+	}
+
+	return requestID, nil
+}
+
+// discardUpload deletes the uploaded file of the request that couldn't be queued from the storage.
+func (s *Server) discardUpload(ctx context.Context, data model.ConversionData) {
+	err := s.storage.DeleteFile(data.FileID, data.SourceFormat)
+	if err != nil {
+		logger.Error(ctx, fmt.Errorf("can't delete uploaded file %s: %w", data.FileID, err))
+	}
+}
+
+// ConversionStatus shows the status of a single conversion request of a user.
+func (s *Server) ConversionStatus(w http.ResponseWriter, r *http.Request) {
+	requestID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(requestID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't get request: %w", repository.ErrNoSuchRequest))
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := s.repo.GetRequest(requestID, userID)
+	if err == repository.ErrNoSuchRequest {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't get request: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get request", err, http.StatusInternalServerError)
+		return
+	}
+
+	res.Respond(w, http.StatusOK, resp)
+}
+
+// CancelConversion cancels a queued or processing conversion request of a user.
+// The converter skips the cancelled request or interrupts its running conversion.
+func (s *Server) CancelConversion(w http.ResponseWriter, r *http.Request) {
+	requestID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(requestID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't cancel request: %w", repository.ErrNoSuchRequest))
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	err := s.repo.CancelRequest(requestID, userID)
+	if err == repository.ErrNoSuchRequest {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't cancel request: %w", err))
+		return
+	}
+	if err == repository.ErrRequestFinished {
+		res.RespondErr(w, http.StatusConflict, fmt.Errorf("can't cancel request: %w", err))
+		return
+	}
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't cancel request", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ConversionEvents streams status changes of user's conversion requests as server-sent events.
+func (s *Server) ConversionEvents(w http.ResponseWriter, r *http.Request) {
+	const keepAliveInterval = 15 * time.Second
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	statusEvents, unsubscribe := s.hub.Subscribe(userID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-statusEvents:
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Error(r.Context(), fmt.Errorf("can't marshal event: %w", err))
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: status\ndata: %s\n\n", event.RequestID, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// RequestHistory shows a page of request history of a user, filtered and sorted by the query.
+// The page is an array, so that it stays compatible with the unpaged history, and the cursor
+// of the next page is returned in the header. The next page is requested with it and the same query.
 func (s *Server) RequestHistory(w http.ResponseWriter, r *http.Request) {
+	historyQuery, limit, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		res.RespondErr(w, http.StatusBadRequest, fmt.Errorf("invalid query: %w", err))
+		return
+	}
+
 	userID, ok := appcontext.GetUserID(r.Context())
 	if !ok {
 		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
 		return
 	}
 
-	resp, err := s.repo.GetRequestHistory(userID)
+	reqs, err := s.repo.GetRequestHistory(userID, historyQuery)
 	if err != nil {
 		logAndRespondErr(r.Context(), w, "can't get request history", err, http.StatusInternalServerError)
 		return
 	}
 
-	res.Respond(w, http.StatusOK, resp)
+	if len(reqs) > limit {
+		reqs = reqs[:limit]
+		w.Header().Set(nextCursorHeader, encodeCursor(reqs[limit-1]))
+	}
+
+	res.Respond(w, http.StatusOK, reqs)
 }
 
 // Download implements audio downloading.
 func (s *Server) Download(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	audioID := vars["id"]
+	if _, err := uuid.Parse(audioID); err != nil {
+		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't get audio: %w", repository.ErrNoSuchAudio))
+		return
+	}
+
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
 
-	audioInfo, err := s.repo.GetAudioByID(audioID)
+	audioInfo, err := s.repo.GetAudioByID(audioID, userID)
 	if err == repository.ErrNoSuchAudio {
 		res.RespondErr(w, http.StatusNotFound, fmt.Errorf("can't get audio: %w", err))
 		return
 	}
+	if err == repository.ErrAudioExpired {
+		res.RespondErr(w, http.StatusGone, fmt.Errorf("can't get audio: %w", err))
+		return
+	}
 	if err != nil {
 		logAndRespondErr(r.Context(), w, "can't get audio", err, http.StatusInternalServerError)
 		return
@@ -283,6 +696,61 @@ func (s *Server) Download(w http.ResponseWriter, r *http.Request) {
 	res.Respond(w, http.StatusOK, downloadResp)
 }
 
+// parseConversionParams parses optional encoding parameters from the conversion request form.
+func parseConversionParams(form url.Values) (model.ConversionParams, error) {
+	var params model.ConversionParams
+	fields := map[string]*int{
+		"bitrate":    &params.Bitrate,
+		"sampleRate": &params.SampleRate,
+		"channels":   &params.Channels,
+		"bitDepth":   &params.BitDepth,
+	}
+	for name, field := range fields {
+		value := form.Get(name)
+		if value == "" {
+			continue
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil || v <= 0 {
+			return model.ConversionParams{}, fmt.Errorf("%s must be a positive integer", name)
+		}
+		*field = v
+	}
+
+	if value := form.Get("quality"); value != "" {
+		quality, err := strconv.Atoi(value)
+		if err != nil {
+			return model.ConversionParams{}, errors.New("quality must be an integer")
+		}
+		params.Quality = &quality
+	}
+
+	return params, nil
+}
+
+// parsePagination parses the limit and the offset of a listed page from the query.
+func parsePagination(query url.Values, defaultLimit, maxLimit int) (int, int, error) {
+	limit := defaultLimit
+	if value := query.Get("limit"); value != "" {
+		l, err := strconv.Atoi(value)
+		if err != nil || l <= 0 || l > maxLimit {
+			return 0, 0, fmt.Errorf("limit must be from 1 to %d", maxLimit)
+		}
+		limit = l
+	}
+
+	var offset int
+	if value := query.Get("offset"); value != "" {
+		o, err := strconv.Atoi(value)
+		if err != nil || o < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+		offset = o
+	}
+
+	return limit, offset, nil
+}
+
 func logAndRespondErr(ctx context.Context, w http.ResponseWriter, wrapper string, err error, code int) {
 	errMsg := fmt.Errorf(wrapper+": %w", err)
 	logger.Error(ctx, errMsg)
@@ -0,0 +1,596 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/katiasuya/audio-conversion-service/internal/appcontext"
+	"github.com/katiasuya/audio-conversion-service/internal/auth"
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/queue"
+	"github.com/katiasuya/audio-conversion-service/internal/repository"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+	"github.com/katiasuya/audio-conversion-service/pkg/hash"
+	"github.com/streadway/amqp"
+)
+
+type fakeStorage struct{}
+
+func (fakeStorage) UploadFile(r io.Reader, _ string) (string, error) {
+	_, err := io.Copy(ioutil.Discard, r)
+	return "file-id", err
+}
+func (fakeStorage) SaveFile(io.Reader, string, string) error  { return nil }
+func (fakeStorage) DownloadFile(string, string, string) error { return nil }
+func (fakeStorage) DeleteFile(string, string) error           { return nil }
+func (fakeStorage) GetDownloadURL(id, format string) (string, error) {
+	return "http://files/" + id + "." + format, nil
+}
+
+// recordingStorage records the deleted files.
+type recordingStorage struct {
+	fakeStorage
+	deleted []string
+}
+
+func (s *recordingStorage) DeleteFile(id, format string) error {
+	s.deleted = append(s.deleted, id+"."+format)
+	return nil
+}
+
+// fakeChannel fails to publish messages when publishErr is set.
+type fakeChannel struct {
+	publishErr error
+}
+
+func (fakeChannel) Qos(int, int, bool) error { return nil }
+func (fakeChannel) Consume(string, string, bool, bool, bool, bool, amqp.Table) (<-chan amqp.Delivery, error) {
+	return nil, nil
+}
+func (fakeChannel) Cancel(string, bool) error               { return nil }
+func (fakeChannel) QueueInspect(string) (amqp.Queue, error) { return amqp.Queue{}, nil }
+func (ch fakeChannel) Publish(string, string, bool, bool, amqp.Publishing) error {
+	return ch.publishErr
+}
+
+// TestDownload tests that Download gives the link only to the owner of the audio.
+func TestDownload(t *testing.T) {
+	const (
+		audioID = "2a4159de-9f06-4920-a9f6-6f612fd0acf5"
+		ownerID = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+		otherID = "12feccec-3974-4dc2-ac63-b4838c7bf0eb"
+	)
+
+	tests := []struct {
+		name    string
+		audioID string
+		userID  string
+		owned   bool
+		expired bool
+		expCode int
+	}{
+		{
+			name:    "owner",
+			audioID: audioID,
+			userID:  ownerID,
+			owned:   true,
+			expCode: http.StatusOK,
+		},
+		{
+			name:    "expired",
+			audioID: audioID,
+			userID:  ownerID,
+			owned:   true,
+			expired: true,
+			expCode: http.StatusGone,
+		},
+		{
+			name:    "other user",
+			audioID: audioID,
+			userID:  otherID,
+			owned:   false,
+			expCode: http.StatusNotFound,
+		},
+		{
+			name:    "malformed id",
+			audioID: "not-an-id",
+			userID:  ownerID,
+			expCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			rows := sqlmock.NewRows([]string{"name", "format", "location", "expired"})
+			if tt.owned {
+				rows.AddRow("song", "mp3", "file-id", tt.expired)
+			}
+			mock.ExpectQuery(`SELECT a.name, a.format, a.location, a.expired IS NOT NULL FROM converter.audio a`).
+				WithArgs(tt.audioID, tt.userID).
+				WillReturnRows(rows)
+
+			s := New(repository.New(db), fakeStorage{}, nil, nil, nil, config.QuotaData{}, "")
+			req := httptest.NewRequest(http.MethodGet, "/download_audio/"+tt.audioID, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.audioID})
+			req = req.WithContext(appcontext.AddUserID(req.Context(), tt.userID))
+			rec := httptest.NewRecorder()
+
+			s.Download(rec, req)
+
+			if rec.Code != tt.expCode {
+				t.Fatalf("Expected %d, got %d: %s", tt.expCode, rec.Code, rec.Body.String())
+			}
+			if tt.expCode == http.StatusOK {
+				var resp struct{ FileURL string }
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.FileURL != "http://files/file-id.mp3" {
+					t.Errorf("Expected file URL, got %s", resp.FileURL)
+				}
+			}
+		})
+	}
+}
+
+// TestConversionStatus tests that ConversionStatus shows the request only to its owner.
+func TestConversionStatus(t *testing.T) {
+	const (
+		requestID = "9b0b5bd4-4c53-4e43-8c5c-2b0ab1c1b3c1"
+		ownerID   = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+		otherID   = "12feccec-3974-4dc2-ac63-b4838c7bf0eb"
+	)
+
+	tests := []struct {
+		name      string
+		requestID string
+		userID    string
+		owned     bool
+		expCode   int
+	}{
+		{
+			name:      "owner",
+			requestID: requestID,
+			userID:    ownerID,
+			owned:     true,
+			expCode:   http.StatusOK,
+		},
+		{
+			name:      "other user",
+			requestID: requestID,
+			userID:    otherID,
+			owned:     false,
+			expCode:   http.StatusNotFound,
+		},
+		{
+			name:      "malformed id",
+			requestID: "not-an-id",
+			userID:    ownerID,
+			expCode:   http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			created := time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC)
+			rows := sqlmock.NewRows([]string{"id", "status", "source_id", "source_format", "target_id", "target_format",
+				"created", "updated", "error_code", "failure_reason", "progress"})
+			if tt.owned {
+				rows.AddRow(requestID, "processing", "source-id", "wav", nil, "mp3", created, created, nil, nil, 40)
+			}
+			mock.ExpectQuery(`SELECT id, status, source_id, source_format, target_id, target_format, created, updated`).
+				WithArgs(tt.requestID, tt.userID).
+				WillReturnRows(rows)
+
+			s := New(repository.New(db), nil, nil, nil, nil, config.QuotaData{}, "")
+			req := httptest.NewRequest(http.MethodGet, "/conversion/"+tt.requestID, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.requestID})
+			req = req.WithContext(appcontext.AddUserID(req.Context(), tt.userID))
+			rec := httptest.NewRecorder()
+
+			s.ConversionStatus(rec, req)
+
+			if rec.Code != tt.expCode {
+				t.Fatalf("Expected %d, got %d: %s", tt.expCode, rec.Code, rec.Body.String())
+			}
+			if tt.expCode == http.StatusOK {
+				var resp model.RequestStatus
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				exp := model.RequestStatus{ID: requestID, Status: "processing", SourceID: "source-id", SourceFormat: "wav",
+					TargetFormat: "mp3", Created: created, Updated: created, Progress: 40}
+				if resp != exp {
+					t.Errorf("Expected %+v, got %+v", exp, resp)
+				}
+			}
+		})
+	}
+}
+
+// TestRequestHistory tests that the history page is an array and the cursor of the next page is in the header.
+func TestRequestHistory(t *testing.T) {
+	const userID = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+	ids := []string{"9b0b5bd4-4c53-4e43-8c5c-2b0ab1c1b3c1", "12feccec-3974-4dc2-ac63-b4838c7bf0eb"}
+	created := time.Date(2021, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		rows      int
+		expCursor bool
+	}{
+		{
+			name:      "more requests",
+			rows:      2,
+			expCursor: true,
+		},
+		{
+			name:      "last page",
+			rows:      1,
+			expCursor: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			rows := sqlmock.NewRows([]string{"id", "name", "source_format", "target_format", "created", "updated", "status",
+				"error_code", "failure_reason", "progress"})
+			for _, id := range ids[:tt.rows] {
+				rows.AddRow(id, "song", "wav", "mp3", created, created, "done", nil, nil, 100)
+			}
+			mock.ExpectQuery(`r.status = \$2::status`).
+				WithArgs(userID, "done", nil, nil, nil, nil, nil, nil, 2).
+				WillReturnRows(rows)
+
+			s := New(repository.New(db), nil, nil, nil, nil, config.QuotaData{}, "")
+			req := httptest.NewRequest(http.MethodGet, "/request_history?status=done&limit=1", nil)
+			req = req.WithContext(appcontext.AddUserID(req.Context(), userID))
+			rec := httptest.NewRecorder()
+
+			s.RequestHistory(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			var resp []model.RequestInfo
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp) != 1 || resp[0].ID != ids[0] {
+				t.Errorf("Expected the first request, got %+v", resp)
+			}
+
+			expCursor := ""
+			if tt.expCursor {
+				expCursor = encodeCursor(model.RequestInfo{ID: ids[0], Created: created})
+			}
+			if cursor := rec.Header().Get(nextCursorHeader); cursor != expCursor {
+				t.Errorf("Expected %q, got %q", expCursor, cursor)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestQueueRequest tests that the uploaded file is deleted when the request can't be made or queued.
+func TestQueueRequest(t *testing.T) {
+	const (
+		requestID = "9b0b5bd4-4c53-4e43-8c5c-2b0ab1c1b3c1"
+		userID    = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+	)
+
+	tests := []struct {
+		name       string
+		makeErr    error
+		publishErr error
+		expDeleted []string
+	}{
+		{
+			name:       "queued",
+			expDeleted: nil,
+		},
+		{
+			name:       "request not made",
+			makeErr:    errors.New("connection refused"),
+			expDeleted: []string{"file-id.mp3"},
+		},
+		{
+			name:       "request not queued",
+			publishErr: amqp.ErrClosed,
+			expDeleted: []string{"file-id.mp3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			query := mock.ExpectQuery(`WITH audio_id AS \(INSERT INTO converter.audio`)
+			if tt.makeErr != nil {
+				query.WillReturnError(tt.makeErr)
+			} else {
+				query.WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(requestID))
+			}
+			if tt.publishErr != nil {
+				mock.ExpectExec(`DELETE FROM converter.request WHERE id=\$1`).
+					WithArgs(requestID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			storage := &recordingStorage{}
+			queueMgr := queue.New(&config.RabbitMQData{QueueName: "conversion"}, fakeChannel{publishErr: tt.publishErr}, nil)
+			s := New(repository.New(db), storage, nil, queueMgr, nil, config.QuotaData{}, "")
+
+			data := model.ConversionData{FileID: "file-id", Filename: "song", SourceFormat: "mp3", TargetFormat: "wav"}
+			res, err := s.queueRequest(context.Background(), userID, data, model.AudioProperties{Size: 10})
+
+			expErr := tt.makeErr != nil || tt.publishErr != nil
+			if (err != nil) != expErr {
+				t.Fatalf("Expected error %v, got %v", expErr, err)
+			}
+			if !expErr && res != requestID {
+				t.Errorf("Expected %v, got %v", requestID, res)
+			}
+			if !reflect.DeepEqual(storage.deleted, tt.expDeleted) {
+				t.Errorf("Expected %v, got %v", tt.expDeleted, storage.deleted)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+type fakeRevocationList map[string]bool
+
+func (l fakeRevocationList) RevokeToken(id string, _ time.Time) error { l[id] = true; return nil }
+func (l fakeRevocationList) IsTokenRevoked(id string) (bool, error)   { return l[id], nil }
+
+func newTestTokenManager(t *testing.T, revoked auth.RevocationList) *auth.TokenManager {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+	return auth.New(&config.JWTKeys{
+		PrivateKey:     string(privateKey),
+		PublicKey:      string(publicKey),
+		AccessTokenTTL: time.Minute,
+	}, revoked)
+}
+
+// TestRequireRole tests that admin routes are available only to users with admin role in the token.
+func TestRequireRole(t *testing.T) {
+	tokenMgr := newTestTokenManager(t, fakeRevocationList{})
+
+	s := New(nil, nil, tokenMgr, nil, nil, config.QuotaData{}, "")
+	handler := s.IsAuthorized(s.RequireRole(model.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	for role, expCode := range map[string]int{model.RoleAdmin: http.StatusOK, model.RoleUser: http.StatusForbidden} {
+		token, err := tokenMgr.NewJWT(uuid.NewString(), role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/admin/requests", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != expCode {
+			t.Errorf("Expected %d for %s, got %d", expCode, role, rec.Code)
+		}
+	}
+}
+
+// TestRevokedToken tests that a revoked access token is rejected.
+func TestRevokedToken(t *testing.T) {
+	tokenMgr := newTestTokenManager(t, fakeRevocationList{})
+	s := New(nil, nil, tokenMgr, nil, nil, config.QuotaData{}, "")
+	var tokenID string
+	handler := s.IsAuthorized(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenID, _ = appcontext.GetTokenID(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	token, err := tokenMgr.NewJWT(uuid.NewString(), model.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expCode := range []int{http.StatusOK, http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/request_history", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != expCode {
+			t.Fatalf("Expected %d, got %d", expCode, rec.Code)
+		}
+		if err := tokenMgr.RevokeJWT(tokenID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestAPIKeyAccess tests that API keys are limited by their scopes
+// and can't be used for key management and admin routes.
+func TestAPIKeyAccess(t *testing.T) {
+	const userID = "61e72557-e5af-4bc2-b19e-b1e4c7820d14"
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		expCode int
+	}{
+		{
+			name:    "write without write scope",
+			method:  http.MethodPost,
+			path:    "/conversion",
+			expCode: http.StatusForbidden,
+		},
+		{
+			name:    "key management",
+			method:  http.MethodGet,
+			path:    "/api_keys",
+			expCode: http.StatusForbidden,
+		},
+		{
+			name:    "admin route",
+			method:  http.MethodGet,
+			path:    "/admin/stats",
+			expCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery(`UPDATE converter.api_key SET last_used=NOW\(\) WHERE key_hash=\$1`).
+				WithArgs(hash.TokenHash("acs_key")).
+				WillReturnRows(sqlmock.NewRows([]string{"user_id", "scopes"}).AddRow(userID, "{read}"))
+
+			router := mux.NewRouter()
+			New(repository.New(db), nil, nil, nil, nil, config.QuotaData{}, "").RegisterRoutes(router)
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-API-Key", "acs_key")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expCode {
+				t.Errorf("Expected %d, got %d", tt.expCode, rec.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestConversionRequestUpload tests that the streamed file must be the last part of the form
+// and must not exceed the upload limit.
+func TestConversionRequestUpload(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileFirst bool
+		size      int
+		content   []byte
+		expCode   int
+	}{
+		{
+			name:    "file too large",
+			size:    2048,
+			expCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:    "content not matching the format",
+			content: []byte("%PDF-1.7"),
+			expCode: http.StatusBadRequest,
+		},
+		{
+			name:      "file before fields",
+			fileFirst: true,
+			size:      10,
+			expCode:   http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			writeFile := func() {
+				header := make(textproto.MIMEHeader)
+				header.Set("Content-Disposition", `form-data; name="file"; filename="song.mp3"`)
+				header.Set("Content-Type", "audio/mpeg")
+				part, err := mw.CreatePart(header)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.content == nil {
+					tt.content = bytes.Repeat([]byte{0}, tt.size)
+				}
+				part.Write(tt.content)
+			}
+			if tt.fileFirst {
+				writeFile()
+			}
+			mw.WriteField("sourceFormat", "mp3")
+			mw.WriteField("targetFormat", "wav")
+			if !tt.fileFirst {
+				writeFile()
+			}
+			mw.Close()
+
+			s := New(nil, fakeStorage{}, nil, nil, nil, config.QuotaData{MaxUploadBytes: 1024}, "")
+			req := httptest.NewRequest(http.MethodPost, "/conversion", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rec := httptest.NewRecorder()
+
+			s.ConversionRequest(rec, req)
+
+			if rec.Code != tt.expCode {
+				t.Errorf("Expected %d, got %d: %s", tt.expCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/katiasuya/audio-conversion-service/internal/format"
+	"github.com/katiasuya/audio-conversion-service/internal/repository"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// nextCursorHeader is the response header containing the cursor of the next page of the history.
+const nextCursorHeader = "X-Next-Cursor"
+
+// Sort orders of the request history.
+const (
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+var (
+	errInvalidStatus = fmt.Errorf("invalid status, need one of: %s", strings.Join([]string{model.StatusQueued,
+		model.StatusProcessing, model.StatusDone, model.StatusFailed, model.StatusCancelled}, ", "))
+	errInvalidFormat    = fmt.Errorf("invalid format, need one of: %s", strings.Join(format.Names(), ", "))
+	errInvalidTime      = errors.New("from and to must be RFC 3339 times, e.g. 2021-03-01T10:00:00Z")
+	errInvalidTimeRange = errors.New("from must be before to")
+	errInvalidOrder     = fmt.Errorf("invalid order, need %s or %s", orderAsc, orderDesc)
+	errInvalidCursor    = errors.New("invalid cursor")
+)
+
+// parseHistoryQuery parses filters, sort order and the page of the request history from the query.
+// It requests one more item than the limit to find out whether there is a next page.
+func parseHistoryQuery(query url.Values) (repository.HistoryQuery, int, error) {
+	var historyQuery repository.HistoryQuery
+
+	historyQuery.Status = query.Get("status")
+	switch historyQuery.Status {
+	case "", model.StatusQueued, model.StatusProcessing, model.StatusDone, model.StatusFailed, model.StatusCancelled:
+	default:
+		return repository.HistoryQuery{}, 0, errInvalidStatus
+	}
+
+	historyQuery.SourceFormat = strings.ToLower(query.Get("sourceFormat"))
+	historyQuery.TargetFormat = strings.ToLower(query.Get("targetFormat"))
+	for _, name := range []string{historyQuery.SourceFormat, historyQuery.TargetFormat} {
+		if _, ok := format.Get(name); name != "" && !ok {
+			return repository.HistoryQuery{}, 0, errInvalidFormat
+		}
+	}
+
+	times := map[string]*time.Time{
+		"from": &historyQuery.CreatedFrom,
+		"to":   &historyQuery.CreatedTo,
+	}
+	for name, field := range times {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return repository.HistoryQuery{}, 0, errInvalidTime
+		}
+		*field = t.UTC()
+	}
+	if !historyQuery.CreatedFrom.IsZero() && !historyQuery.CreatedTo.IsZero() &&
+		!historyQuery.CreatedFrom.Before(historyQuery.CreatedTo) {
+		return repository.HistoryQuery{}, 0, errInvalidTimeRange
+	}
+
+	switch query.Get("order") {
+	case "", orderDesc:
+	case orderAsc:
+		historyQuery.Ascending = true
+	default:
+		return repository.HistoryQuery{}, 0, errInvalidOrder
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return repository.HistoryQuery{}, 0, err
+		}
+		historyQuery.After = &cursor
+	}
+
+	limit, _, err := parsePagination(query, defaultHistoryLimit, maxHistoryLimit)
+	if err != nil {
+		return repository.HistoryQuery{}, 0, err
+	}
+	historyQuery.Limit = limit + 1
+
+	return historyQuery, limit, nil
+}
+
+// encodeCursor encodes the position of the request in the history to an opaque cursor.
+func encodeCursor(req model.RequestInfo) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(req.Created.UTC().Format(time.RFC3339Nano) + "," + req.ID))
+}
+
+// decodeCursor decodes the position of the request in the history from the cursor.
+func decodeCursor(cursor string) (repository.HistoryCursor, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return repository.HistoryCursor{}, errInvalidCursor
+	}
+
+	parts := strings.SplitN(string(value), ",", 2)
+	if len(parts) != 2 {
+		return repository.HistoryCursor{}, errInvalidCursor
+	}
+	created, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return repository.HistoryCursor{}, errInvalidCursor
+	}
+	if _, err := uuid.Parse(parts[1]); err != nil {
+		return repository.HistoryCursor{}, errInvalidCursor
+	}
+
+	return repository.HistoryCursor{Created: created, ID: parts[1]}, nil
+}
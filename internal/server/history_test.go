@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// TestParseHistoryQuery tests parseHistoryQuery function.
+func TestParseHistoryQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query url.Values
+		exp   error
+	}{
+		{
+			name:  "empty query",
+			query: url.Values{},
+			exp:   nil,
+		},
+		{
+			name: "all filters",
+			query: url.Values{"status": {"done"}, "sourceFormat": {"WAV"}, "targetFormat": {"mp3"},
+				"from": {"2021-03-01T00:00:00Z"}, "to": {"2021-04-01T00:00:00Z"}, "order": {"asc"}, "limit": {"10"}},
+			exp: nil,
+		},
+		{
+			name:  "invalid status",
+			query: url.Values{"status": {"lost"}},
+			exp:   errInvalidStatus,
+		},
+		{
+			name:  "invalid format",
+			query: url.Values{"targetFormat": {"xyz"}},
+			exp:   errInvalidFormat,
+		},
+		{
+			name:  "invalid time",
+			query: url.Values{"from": {"2021-03-01"}},
+			exp:   errInvalidTime,
+		},
+		{
+			name:  "empty time range",
+			query: url.Values{"from": {"2021-04-01T00:00:00Z"}, "to": {"2021-03-01T00:00:00Z"}},
+			exp:   errInvalidTimeRange,
+		},
+		{
+			name:  "invalid order",
+			query: url.Values{"order": {"up"}},
+			exp:   errInvalidOrder,
+		},
+		{
+			name:  "invalid cursor",
+			query: url.Values{"cursor": {"bm90LWEtY3Vyc29y"}},
+			exp:   errInvalidCursor,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseHistoryQuery(tt.query)
+			if err != tt.exp {
+				t.Errorf("Expected %v, got %v", tt.exp, err)
+			}
+		})
+	}
+}
+
+// TestHistoryCursor tests that the decoded cursor points to the encoded request.
+func TestHistoryCursor(t *testing.T) {
+	req := model.RequestInfo{
+		ID:      "2a4159de-9f06-4920-a9f6-6f612fd0acf5",
+		Created: time.Date(2021, time.March, 1, 10, 0, 0, 123456000, time.UTC),
+	}
+
+	cursor, err := decodeCursor(encodeCursor(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.ID != req.ID || !cursor.Created.Equal(req.Created) {
+		t.Errorf("Expected %s at %v, got %s at %v", req.ID, req.Created, cursor.ID, cursor.Created)
+	}
+}
@@ -2,6 +2,40 @@ package model
 
 import "time"
 
+// Roles of the users.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Tiers of the users that define how long their audio files are stored.
+const (
+	TierFree    = "free"
+	TierPremium = "premium"
+)
+
+// Scopes of the API keys.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Statuses of the conversion requests.
+const (
+	StatusQueued     = "queued"
+	StatusProcessing = "processing"
+	StatusDone       = "done"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+)
+
+// Statuses of the webhook deliveries.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
 // AudioInfo represents downloaded audio information.
 type AudioInfo struct {
 	Name     string `json:"name"`
@@ -11,11 +45,149 @@ type AudioInfo struct {
 
 // RequestInfo represents a history response.
 type RequestInfo struct {
-	ID           string    `json:"ID"`
-	AudioName    string    `json:"audioName"`
-	SourceFormat string    `json:"sourceFormat"`
-	TargetFormat string    `json:"targetFormat"`
-	Created      time.Time `json:"created"`
-	Updated      time.Time `json:"updated"`
-	Status       string    `json:"status"`
+	ID            string    `json:"ID"`
+	AudioName     string    `json:"audioName"`
+	SourceFormat  string    `json:"sourceFormat"`
+	TargetFormat  string    `json:"targetFormat"`
+	Created       time.Time `json:"created"`
+	Updated       time.Time `json:"updated"`
+	Status        string    `json:"status"`
+	ErrorCode     string    `json:"errorCode,omitempty"`
+	FailureReason string    `json:"failureReason,omitempty"`
+	Progress      int       `json:"progress"`
+}
+
+// RequestStatus represents a status response of a single conversion request.
+type RequestStatus struct {
+	ID            string    `json:"ID"`
+	Status        string    `json:"status"`
+	SourceID      string    `json:"sourceID"`
+	SourceFormat  string    `json:"sourceFormat"`
+	TargetID      string    `json:"targetID,omitempty"`
+	TargetFormat  string    `json:"targetFormat"`
+	Created       time.Time `json:"created"`
+	Updated       time.Time `json:"updated"`
+	ErrorCode     string    `json:"errorCode,omitempty"`
+	FailureReason string    `json:"failureReason,omitempty"`
+	Progress      int       `json:"progress"`
+}
+
+// AdminRequestInfo represents a conversion request shown to admins.
+type AdminRequestInfo struct {
+	ID            string    `json:"ID"`
+	UserID        string    `json:"userID"`
+	SourceFormat  string    `json:"sourceFormat"`
+	TargetFormat  string    `json:"targetFormat"`
+	Created       time.Time `json:"created"`
+	Updated       time.Time `json:"updated"`
+	Status        string    `json:"status"`
+	ErrorCode     string    `json:"errorCode,omitempty"`
+	FailureReason string    `json:"failureReason,omitempty"`
+}
+
+// UserInfo represents a user shown to admins.
+type UserInfo struct {
+	ID       string    `json:"ID"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	Tier     string    `json:"tier"`
+	Created  time.Time `json:"created"`
+}
+
+// APIKeyInfo represents an API key of a user. The key itself is set only when the key is created.
+type APIKeyInfo struct {
+	ID       string     `json:"ID"`
+	Name     string     `json:"name"`
+	Key      string     `json:"key,omitempty"`
+	Prefix   string     `json:"prefix"`
+	Scopes   []string   `json:"scopes"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+	Created  time.Time  `json:"created"`
+}
+
+// Usage represents the resources used by a user or the limits of their usage.
+type Usage struct {
+	RequestsPerMinute int     `json:"requestsPerMinute"`
+	QueuedJobs        int     `json:"queuedJobs"`
+	StoredBytes       int64   `json:"storedBytes"`
+	MonthlyMinutes    float64 `json:"monthlyMinutes"`
+}
+
+// Stats represents system statistics.
+type Stats struct {
+	Users    int            `json:"users"`
+	Audio    int            `json:"audio"`
+	Requests map[string]int `json:"requests"`
+	Queue    QueueStats     `json:"queue"`
+}
+
+// QueueStats represents the numbers of messages in the conversion queues.
+type QueueStats struct {
+	Queued       int `json:"queued"`
+	DeadLettered int `json:"deadLettered"`
+}
+
+// AudioProperties represents properties of a stored audio file.
+type AudioProperties struct {
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"`
+	Codec    string `json:"codec,omitempty"`
+	// Duration is a duration in seconds.
+	Duration   float64 `json:"duration,omitempty"`
+	Channels   int     `json:"channels,omitempty"`
+	SampleRate int     `json:"sampleRate,omitempty"`
+	// Bitrate is a bitrate in bit/s.
+	Bitrate int `json:"bitrate,omitempty"`
+}
+
+// AudioMetadata represents an audio file with its properties.
+// Expired is set if the file has been deleted by the retention policy.
+type AudioMetadata struct {
+	ID      string     `json:"ID"`
+	Name    string     `json:"name"`
+	Format  string     `json:"format"`
+	Created time.Time  `json:"created"`
+	Expired *time.Time `json:"expired,omitempty"`
+	AudioProperties
+}
+
+// AudioFile represents the file of an audio in the storage.
+type AudioFile struct {
+	AudioID  string
+	Location string
+	Format   string
+}
+
+// WebhookDelivery represents a webhook notification waiting to be sent.
+// Attempt is the number of attempts already made.
+type WebhookDelivery struct {
+	ID        string
+	RequestID string
+	URL       string
+	Payload   []byte
+	Attempt   int
+}
+
+// ConversionParams represents optional encoding parameters of a conversion.
+type ConversionParams struct {
+	// Bitrate is a constant bitrate in kbit/s.
+	Bitrate int `json:"bitrate,omitempty"`
+	// Quality is a variable bitrate quality level from 0 (best) to 9 (worst).
+	Quality    *int `json:"quality,omitempty"`
+	SampleRate int  `json:"sampleRate,omitempty"`
+	Channels   int  `json:"channels,omitempty"`
+	BitDepth   int  `json:"bitDepth,omitempty"`
+}
+
+// ConversionData represents the data of a conversion request sent to the converter.
+type ConversionData struct {
+	FileID       string
+	Filename     string
+	SourceFormat string
+	TargetFormat string
+	RequestID    string
+	Params       ConversionParams
+	CallbackURL  string
+	// Duration is the duration of the source audio in seconds, zero if unknown.
+	Duration float64
 }
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/appcontext"
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/logger"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+	res "github.com/katiasuya/audio-conversion-service/internal/server/response"
+)
+
+// queuedJobsRetryAfter is the time after which a user with too many queued jobs may try again.
+const queuedJobsRetryAfter = time.Minute
+
+var (
+	errRateLimited       = errors.New("too many conversion requests per minute")
+	errTooManyQueuedJobs = errors.New("too many conversion requests are waiting or being converted")
+	errStorageQuota      = errors.New("stored audio quota is exceeded")
+	errMonthlyQuota      = errors.New("monthly conversion minutes quota is exceeded")
+)
+
+type uploadReserver interface {
+	ReserveUpload(userID string, monthStart time.Time, check func(usage model.Usage, rateReset time.Duration) error) (string, error)
+	FinishUpload(attemptID string) error
+}
+
+// CheckQuota is a middleware that rejects conversion requests of users who have exceeded their limits.
+// The upload is reserved before its body is read, so that concurrent uploads are counted towards the limits
+// of each other, and the reservation is finished when the request ends.
+func (s *Server) CheckQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := appcontext.GetUserID(r.Context())
+		if !ok {
+			logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+			return
+		}
+
+		now := time.Now()
+		var retryAfter time.Duration
+		var quotaErr error
+		attemptID, err := s.uploads.ReserveUpload(userID, monthStart(now), func(usage model.Usage, rateReset time.Duration) error {
+			retryAfter, quotaErr = checkQuota(s.quotas, usage, rateReset, now)
+			return quotaErr
+		})
+		if quotaErr != nil {
+			respondQuotaErr(w, retryAfter, quotaErr)
+			return
+		}
+		if err != nil {
+			logAndRespondErr(r.Context(), w, "can't reserve upload", err, http.StatusInternalServerError)
+			return
+		}
+		defer func() {
+			err := s.uploads.FinishUpload(attemptID)
+			if err != nil {
+				logger.Error(r.Context(), fmt.Errorf("can't finish upload attempt %s: %w", attemptID, err))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Usage shows the resources used by a user and the limits of their usage.
+func (s *Server) Usage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := appcontext.GetUserID(r.Context())
+	if !ok {
+		logAndRespondErr(r.Context(), w, "", errors.New("can't get user id from context"), http.StatusInternalServerError)
+		return
+	}
+
+	usage, _, err := s.repo.GetUsage(userID, monthStart(time.Now()))
+	if err != nil {
+		logAndRespondErr(r.Context(), w, "can't get usage", err, http.StatusInternalServerError)
+		return
+	}
+
+	type response struct {
+		Usage  model.Usage `json:"usage"`
+		Limits model.Usage `json:"limits"`
+	}
+
+	res.Respond(w, http.StatusOK, response{
+		Usage: usage,
+		Limits: model.Usage{
+			RequestsPerMinute: s.quotas.RequestsPerMinute,
+			QueuedJobs:        s.quotas.MaxQueuedJobs,
+			StoredBytes:       s.quotas.MaxStoredBytes,
+			MonthlyMinutes:    float64(s.quotas.MonthlyMinutes),
+		},
+	})
+}
+
+// checkQuota checks the usage against the limits and returns the error of the first exceeded limit
+// with the time after which the request may succeed, zero if it is unknown.
+func checkQuota(quotas config.QuotaData, usage model.Usage, rateReset time.Duration, now time.Time) (time.Duration, error) {
+	if quotas.RequestsPerMinute > 0 && usage.RequestsPerMinute >= quotas.RequestsPerMinute {
+		return rateReset, errRateLimited
+	}
+	if quotas.MaxQueuedJobs > 0 && usage.QueuedJobs >= quotas.MaxQueuedJobs {
+		return queuedJobsRetryAfter, errTooManyQueuedJobs
+	}
+	if quotas.MaxStoredBytes > 0 && usage.StoredBytes >= quotas.MaxStoredBytes {
+		return 0, errStorageQuota
+	}
+	if quotas.MonthlyMinutes > 0 && usage.MonthlyMinutes >= float64(quotas.MonthlyMinutes) {
+		return monthStart(now).AddDate(0, 1, 0).Sub(now), errMonthlyQuota
+	}
+
+	return 0, nil
+}
+
+// checkMonthlyMinutes checks that converting the audio of the given duration in seconds
+// doesn't exceed the monthly minutes quota and returns the time after which the user may try again.
+func checkMonthlyMinutes(quotas config.QuotaData, usage model.Usage, duration float64, now time.Time) (time.Duration, error) {
+	if quotas.MonthlyMinutes > 0 && usage.MonthlyMinutes+duration/60 > float64(quotas.MonthlyMinutes) {
+		return monthStart(now).AddDate(0, 1, 0).Sub(now), errMonthlyQuota
+	}
+
+	return 0, nil
+}
+
+// respondQuotaErr responds that the quota is exceeded, telling when to try again if it is known.
+func respondQuotaErr(w http.ResponseWriter, retryAfter time.Duration, err error) {
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	res.RespondErr(w, http.StatusTooManyRequests, err)
+}
+
+// monthStart returns the beginning of the month of the given time in UTC.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
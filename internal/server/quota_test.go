@@ -0,0 +1,238 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/appcontext"
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// TestCheckQuota tests checkQuota function.
+func TestCheckQuota(t *testing.T) {
+	quotas := config.QuotaData{
+		RequestsPerMinute: 10,
+		MaxQueuedJobs:     5,
+		MaxStoredBytes:    1000,
+		MonthlyMinutes:    60,
+	}
+	now := time.Date(2021, time.March, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		quotas        config.QuotaData
+		usage         model.Usage
+		expRetryAfter time.Duration
+		exp           error
+	}{
+		{
+			name:   "within limits",
+			quotas: quotas,
+			usage:  model.Usage{RequestsPerMinute: 9, QueuedJobs: 4, StoredBytes: 999, MonthlyMinutes: 59.5},
+			exp:    nil,
+		},
+		{
+			name:          "rate limited",
+			quotas:        quotas,
+			usage:         model.Usage{RequestsPerMinute: 10},
+			expRetryAfter: 20 * time.Second,
+			exp:           errRateLimited,
+		},
+		{
+			name:          "too many queued jobs",
+			quotas:        quotas,
+			usage:         model.Usage{QueuedJobs: 5},
+			expRetryAfter: queuedJobsRetryAfter,
+			exp:           errTooManyQueuedJobs,
+		},
+		{
+			name:   "storage quota",
+			quotas: quotas,
+			usage:  model.Usage{StoredBytes: 1000},
+			exp:    errStorageQuota,
+		},
+		{
+			name:          "monthly quota",
+			quotas:        quotas,
+			usage:         model.Usage{MonthlyMinutes: 60},
+			expRetryAfter: 12 * time.Hour,
+			exp:           errMonthlyQuota,
+		},
+		{
+			name:   "no limits",
+			quotas: config.QuotaData{},
+			usage:  model.Usage{RequestsPerMinute: 100, QueuedJobs: 100, StoredBytes: 1 << 40, MonthlyMinutes: 1e6},
+			exp:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retryAfter, err := checkQuota(tt.quotas, tt.usage, 20*time.Second, now)
+			if err != tt.exp {
+				t.Errorf("Expected %v, got %v", tt.exp, err)
+			}
+			if retryAfter != tt.expRetryAfter {
+				t.Errorf("Expected retry after %v, got %v", tt.expRetryAfter, retryAfter)
+			}
+		})
+	}
+}
+
+// TestCheckMonthlyMinutes tests checkMonthlyMinutes function.
+func TestCheckMonthlyMinutes(t *testing.T) {
+	now := time.Date(2021, time.March, 31, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		quotas        config.QuotaData
+		usage         model.Usage
+		duration      float64
+		expRetryAfter time.Duration
+		exp           error
+	}{
+		{
+			name:     "fits the quota",
+			quotas:   config.QuotaData{MonthlyMinutes: 60},
+			usage:    model.Usage{MonthlyMinutes: 50},
+			duration: 600,
+			exp:      nil,
+		},
+		{
+			name:          "exceeds the quota",
+			quotas:        config.QuotaData{MonthlyMinutes: 60},
+			usage:         model.Usage{MonthlyMinutes: 50},
+			duration:      601,
+			expRetryAfter: 12 * time.Hour,
+			exp:           errMonthlyQuota,
+		},
+		{
+			name:     "no limit",
+			quotas:   config.QuotaData{},
+			usage:    model.Usage{MonthlyMinutes: 1e6},
+			duration: 3600,
+			exp:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retryAfter, err := checkMonthlyMinutes(tt.quotas, tt.usage, tt.duration, now)
+			if err != tt.exp {
+				t.Errorf("Expected %v, got %v", tt.exp, err)
+			}
+			if retryAfter != tt.expRetryAfter {
+				t.Errorf("Expected retry after %v, got %v", tt.expRetryAfter, retryAfter)
+			}
+		})
+	}
+}
+
+// fakeUploads counts the upload attempts under a lock like the user row lock of the repository.
+type fakeUploads struct {
+	mu      sync.Mutex
+	started int
+	active  int
+}
+
+func (u *fakeUploads) ReserveUpload(userID string, monthStart time.Time,
+	check func(usage model.Usage, rateReset time.Duration) error) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	err := check(model.Usage{RequestsPerMinute: u.started, QueuedJobs: u.active}, time.Minute)
+	if err != nil {
+		return "", err
+	}
+	u.started++
+	u.active++
+	return fmt.Sprint(u.started), nil
+}
+
+func (u *fakeUploads) FinishUpload(attemptID string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.active--
+	return nil
+}
+
+// TestCheckQuotaConcurrent tests that concurrent uploads can't exceed the limits together
+// and that a finished upload stops counting as a queued request.
+func TestCheckQuotaConcurrent(t *testing.T) {
+	const uploads = 10
+
+	tests := []struct {
+		name             string
+		quotas           config.QuotaData
+		expAccepted      int
+		expCodeAfterward int
+	}{
+		{
+			name:             "requests per minute",
+			quotas:           config.QuotaData{RequestsPerMinute: 3},
+			expAccepted:      3,
+			expCodeAfterward: http.StatusTooManyRequests,
+		},
+		{
+			name:             "queued jobs",
+			quotas:           config.QuotaData{MaxQueuedJobs: 2},
+			expAccepted:      2,
+			expCodeAfterward: http.StatusAccepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{uploads: &fakeUploads{}, quotas: tt.quotas}
+			entered, release := make(chan struct{}, uploads), make(chan struct{})
+			// The handler stands for reading the body of a slow upload.
+			handler := s.CheckQuota(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				entered <- struct{}{}
+				<-release
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			upload := func() int {
+				req := httptest.NewRequest(http.MethodPost, "/conversion", nil)
+				req = req.WithContext(appcontext.AddUserID(req.Context(), "user-id"))
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			codes := make(chan int, uploads)
+			for i := 0; i < uploads; i++ {
+				go func() { codes <- upload() }()
+			}
+
+			accepted, rejected := 0, 0
+			for accepted+rejected < uploads {
+				select {
+				case <-entered:
+					accepted++
+				case code := <-codes:
+					if code != http.StatusTooManyRequests {
+						t.Fatalf("Expected %d, got %d", http.StatusTooManyRequests, code)
+					}
+					rejected++
+				case <-time.After(time.Second):
+					t.Fatalf("Expected %d uploads to be handled, got %d", uploads, accepted+rejected)
+				}
+			}
+			if accepted != tt.expAccepted {
+				t.Errorf("Expected %d accepted uploads, got %d", tt.expAccepted, accepted)
+			}
+
+			close(release)
+			for i := 0; i < accepted; i++ {
+				<-codes
+			}
+			if code := upload(); code != tt.expCodeAfterward {
+				t.Errorf("Expected %d, got %d", tt.expCodeAfterward, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/katiasuya/audio-conversion-service/internal/format"
+	"github.com/katiasuya/audio-conversion-service/internal/probe"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// maxFormFieldsBytes limits the total size of the form fields sent before the file.
+const maxFormFieldsBytes = 1 << 20
+
+var (
+	errMissingFile     = errors.New("file is missing, it must be the last part of the form")
+	errFormTooLarge    = fmt.Errorf("form fields must be at most %d bytes", maxFormFieldsBytes)
+	errFileTooLarge    = errors.New("file is too large")
+	errNotMultipartReq = errors.New("request must be multipart/form-data")
+	errContentMismatch = errors.New("file content doesn't match the source format")
+	errInvalidAudio    = errors.New("file can't be read as audio")
+)
+
+// readConversionForm reads the form fields of the multipart conversion request up to the file part,
+// which must be the last one, so that the file can be streamed to the storage without buffering.
+func readConversionForm(r *http.Request) (url.Values, *multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, errNotMultipartReq
+	}
+
+	form := make(url.Values)
+	remaining := int64(maxFormFieldsBytes)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, nil, errMissingFile
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't read form: %w", err)
+		}
+
+		if part.FormName() == "file" {
+			return form, part, nil
+		}
+
+		value, err := ioutil.ReadAll(io.LimitReader(part, remaining+1))
+		part.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't read form: %w", err)
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			return nil, nil, errFormTooLarge
+		}
+		form.Add(part.FormName(), string(value))
+	}
+}
+
+// spoolUpload writes the uploaded file to a temporary file in the work directory,
+// so that it can be inspected before it is stored.
+func spoolUpload(source io.Reader, workDir string) (*os.File, error) {
+	file, err := ioutil.TempFile(workDir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("can't create temporary file: %w", err)
+	}
+
+	_, err = io.Copy(file, source)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("can't write temporary file: %w", err)
+	}
+
+	return file, nil
+}
+
+// inspectUpload checks that the content of the spooled file matches the declared source format
+// by its magic bytes and by the codec of its audio stream reported by ffprobe,
+// and returns the probed properties of the audio.
+func inspectUpload(ctx context.Context, file *os.File, sourceFormat string) (probe.Info, error) {
+	header := make([]byte, format.SniffLen)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return probe.Info{}, fmt.Errorf("can't read temporary file: %w", err)
+	}
+	if format.Detect(header[:n]) != sourceFormat {
+		return probe.Info{}, errContentMismatch
+	}
+
+	info, err := probe.Probe(ctx, file.Name())
+	if errors.Is(err, probe.ErrInvalidAudio) || errors.Is(err, probe.ErrNoAudioStream) {
+		return probe.Info{}, errInvalidAudio
+	}
+	if err != nil {
+		return probe.Info{}, err
+	}
+
+	source, ok := format.Get(sourceFormat)
+	if !ok || !source.HasDecoder(info.Codec) {
+		return probe.Info{}, errContentMismatch
+	}
+
+	return info, nil
+}
+
+// uploadReader counts and hashes the bytes of the uploaded file
+// and fails when the file gets larger than the limit.
+type uploadReader struct {
+	r     io.Reader
+	hash  hash.Hash
+	n     int64
+	limit int64
+}
+
+// newUploadReader creates new upload reader, zero limit means no limit.
+func newUploadReader(r io.Reader, limit int64) *uploadReader {
+	return &uploadReader{
+		r:     r,
+		hash:  sha256.New(),
+		limit: limit,
+	}
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	u.n += int64(n)
+	u.hash.Write(p[:n])
+	if u.tooLarge() {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// tooLarge reports whether the file has exceeded the limit.
+func (u *uploadReader) tooLarge() bool {
+	return u.limit > 0 && u.n > u.limit
+}
+
+// properties returns the size and the checksum of the file read so far.
+func (u *uploadReader) properties() model.AudioProperties {
+	return model.AudioProperties{
+		Size:     u.n,
+		Checksum: hex.EncodeToString(u.hash.Sum(nil)),
+	}
+}
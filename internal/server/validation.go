@@ -1,9 +1,15 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"strings"
+
+	"github.com/katiasuya/audio-conversion-service/internal/format"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+	"github.com/katiasuya/audio-conversion-service/internal/webhook"
 )
 
 const (
@@ -12,13 +18,45 @@ const (
 )
 const invalidChars = `:;<>\{}[]+=?&," `
 
-var formats = map[string]string{"mp3": "audio/mpeg", "wav": "audio/wave"}
+const (
+	minQuality = 0
+	maxQuality = 9
+)
+
+const maxKeyNameLength = 100
+
+const maxAudioNameLength = 255
 
 var (
 	errMissingUsername = errors.New("username is missing")
 	errMissingPassword = errors.New("password is missing")
 	errInvalidLength   = fmt.Errorf("invalid length: username and password must be from %d to %d characters", minLength, maxLength)
 	errInvalidChars    = fmt.Errorf("invalid character(s): you can't use %sor space character(s)", invalidChars)
+
+	errMissingSourceFormat = errors.New("source format is missing")
+	errMissingTargetFormat = errors.New("target format is missing")
+	errWrongSourceFormat   = errors.New("wrong source format for the file")
+	errEqualFormats        = errors.New("source and target formats can't be equal")
+	errInvalidTargetFormat = fmt.Errorf("invalid target format, need one of: %s", strings.Join(format.Names(), ", "))
+
+	errBitrateAndQuality   = errors.New("bitrate and quality can't be set together")
+	errBitrateNotSupported = errors.New("bitrate can't be set for the target format")
+	errInvalidBitrate      = errors.New("bitrate is out of range for the target format")
+	errQualityNotSupported = errors.New("quality can't be set for the target format")
+	errInvalidQuality      = fmt.Errorf("invalid quality: need from %d to %d", minQuality, maxQuality)
+	errInvalidSampleRate   = errors.New("sample rate is not supported by the target format")
+	errInvalidChannels     = errors.New("invalid channels: need 1 (mono) or 2 (stereo)")
+	errInvalidBitDepth     = errors.New("bit depth is not supported by the target format")
+
+	errInvalidCallbackURL = errors.New("callback URL must be an absolute http or https URL")
+
+	errMissingKeyName = errors.New("API key name is missing")
+	errInvalidKeyName = fmt.Errorf("API key name must be at most %d characters", maxKeyNameLength)
+	errMissingScopes  = errors.New("API key scopes are missing")
+	errInvalidScope   = fmt.Errorf("invalid API key scope, need %s or %s", model.ScopeRead, model.ScopeWrite)
+
+	errMissingAudioName = errors.New("audio name is missing")
+	errInvalidAudioName = fmt.Errorf("audio name must be at most %d characters", maxAudioNameLength)
 )
 
 // ValidateUserCredentials validates user's credentials.
@@ -49,19 +87,110 @@ func ValidateUserCredentials(username, password string) error {
 // ValidateRequest validates conversion request body.
 func ValidateRequest(name, sourceFormat, targetFormat, sourceContentType string) error {
 	if sourceFormat == "" {
-		return errors.New("source format is missing")
+		return errMissingSourceFormat
 	}
 	if targetFormat == "" {
-		return errors.New("target format is missing")
+		return errMissingTargetFormat
 	}
-	if formats[sourceFormat] != sourceContentType {
-		return errors.New("wrong source format for the file")
+	source, ok := format.Get(sourceFormat)
+	if !ok || !source.HasMIMEType(sourceContentType) {
+		return errWrongSourceFormat
 	}
 	if sourceFormat == targetFormat {
-		return errors.New("source and target formats can't be equal")
+		return errEqualFormats
+	}
+	if _, ok := format.Get(targetFormat); !ok {
+		return errInvalidTargetFormat
+	}
+	if containsInvalidChars(name) {
+		return errInvalidChars
+	}
+
+	return nil
+}
+
+// ValidateParams validates encoding parameters of the conversion to the given target format.
+func ValidateParams(targetFormat string, params model.ConversionParams) error {
+	target, ok := format.Get(targetFormat)
+	if !ok {
+		return errInvalidTargetFormat
+	}
+
+	if params.Bitrate != 0 && params.Quality != nil {
+		return errBitrateAndQuality
+	}
+	if params.Bitrate != 0 {
+		if target.MaxBitrate == 0 {
+			return errBitrateNotSupported
+		}
+		if params.Bitrate < target.MinBitrate || params.Bitrate > target.MaxBitrate {
+			return errInvalidBitrate
+		}
+	}
+	if params.Quality != nil {
+		if target.Qualities == nil {
+			return errQualityNotSupported
+		}
+		if *params.Quality < minQuality || *params.Quality > maxQuality {
+			return errInvalidQuality
+		}
+	}
+	if params.SampleRate != 0 && !target.HasSampleRate(params.SampleRate) {
+		return errInvalidSampleRate
+	}
+	if params.Channels != 0 && params.Channels != 1 && params.Channels != 2 {
+		return errInvalidChannels
+	}
+	if _, ok := target.BitDepthCodecs[params.BitDepth]; params.BitDepth != 0 && !ok {
+		return errInvalidBitDepth
+	}
+
+	return nil
+}
+
+// ValidateCallbackURL validates the optional URL to send webhook notifications to.
+// The host of the URL must resolve only to public addresses.
+func ValidateCallbackURL(ctx context.Context, callbackURL string) error {
+	if callbackURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errInvalidCallbackURL
+	}
+
+	return webhook.CheckURL(ctx, u)
+}
+
+// ValidateAPIKey validates the name and the scopes of a new API key.
+func ValidateAPIKey(name string, scopes []string) error {
+	if name == "" {
+		return errMissingKeyName
+	}
+	if len(name) > maxKeyNameLength {
+		return errInvalidKeyName
+	}
+
+	if len(scopes) == 0 {
+		return errMissingScopes
+	}
+	for _, scope := range scopes {
+		if scope != model.ScopeRead && scope != model.ScopeWrite {
+			return errInvalidScope
+		}
+	}
+
+	return nil
+}
+
+// ValidateAudioName validates the new name of an audio.
+func ValidateAudioName(name string) error {
+	if name == "" {
+		return errMissingAudioName
 	}
-	if _, ok := formats[targetFormat]; !ok {
-		return errors.New("invalid target format, need mp3 or wav")
+	if len(name) > maxAudioNameLength {
+		return errInvalidAudioName
 	}
 	if containsInvalidChars(name) {
 		return errInvalidChars
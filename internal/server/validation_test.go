@@ -1,6 +1,10 @@
 package server
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
 
 // TestValidateUserCredentials tests ValidateUserCredentials function.
 func TestValidateUserCredentials(t *testing.T) {
@@ -57,3 +61,227 @@ func TestValidateUserCredentials(t *testing.T) {
 		})
 	}
 }
+
+// TestValidateRequest tests ValidateRequest function.
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name         string
+		filename     string
+		sourceFormat string
+		targetFormat string
+		contentType  string
+		exp          error
+	}{
+		{
+			name:         "valid request",
+			filename:     "song",
+			sourceFormat: "flac",
+			targetFormat: "opus",
+			contentType:  "audio/flac",
+			exp:          nil,
+		},
+		{
+			name:         "missing source format",
+			filename:     "song",
+			sourceFormat: "",
+			targetFormat: "mp3",
+			contentType:  "audio/wave",
+			exp:          errMissingSourceFormat,
+		},
+		{
+			name:         "missing target format",
+			filename:     "song",
+			sourceFormat: "wav",
+			targetFormat: "",
+			contentType:  "audio/wave",
+			exp:          errMissingTargetFormat,
+		},
+		{
+			name:         "wrong source content type",
+			filename:     "song",
+			sourceFormat: "wav",
+			targetFormat: "mp3",
+			contentType:  "audio/flac",
+			exp:          errWrongSourceFormat,
+		},
+		{
+			name:         "unsupported source format",
+			filename:     "song",
+			sourceFormat: "wma",
+			targetFormat: "mp3",
+			contentType:  "audio/x-ms-wma",
+			exp:          errWrongSourceFormat,
+		},
+		{
+			name:         "equal formats",
+			filename:     "song",
+			sourceFormat: "m4a",
+			targetFormat: "m4a",
+			contentType:  "audio/mp4",
+			exp:          errEqualFormats,
+		},
+		{
+			name:         "invalid target format",
+			filename:     "song",
+			sourceFormat: "mp3",
+			targetFormat: "wma",
+			contentType:  "audio/mpeg",
+			exp:          errInvalidTargetFormat,
+		},
+		{
+			name:         "invalid filename chars",
+			filename:     "my song",
+			sourceFormat: "mp3",
+			targetFormat: "ogg",
+			contentType:  "audio/mpeg",
+			exp:          errInvalidChars,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ValidateRequest(tt.filename, tt.sourceFormat, tt.targetFormat, tt.contentType)
+			if res != tt.exp {
+				t.Errorf("Expected %v, got %v", tt.exp, res)
+			}
+		})
+	}
+}
+
+// TestValidateParams tests ValidateParams function.
+func TestValidateParams(t *testing.T) {
+	quality := func(q int) *int { return &q }
+
+	tests := []struct {
+		name         string
+		targetFormat string
+		params       model.ConversionParams
+		exp          error
+	}{
+		{
+			name:         "no params",
+			targetFormat: "mp3",
+			params:       model.ConversionParams{},
+			exp:          nil,
+		},
+		{
+			name:         "valid cbr params",
+			targetFormat: "mp3",
+			params:       model.ConversionParams{Bitrate: 192, SampleRate: 44100, Channels: 1},
+			exp:          nil,
+		},
+		{
+			name:         "valid vbr params",
+			targetFormat: "ogg",
+			params:       model.ConversionParams{Quality: quality(0)},
+			exp:          nil,
+		},
+		{
+			name:         "bitrate and quality",
+			targetFormat: "mp3",
+			params:       model.ConversionParams{Bitrate: 128, Quality: quality(2)},
+			exp:          errBitrateAndQuality,
+		},
+		{
+			name:         "bitrate for lossless format",
+			targetFormat: "wav",
+			params:       model.ConversionParams{Bitrate: 128},
+			exp:          errBitrateNotSupported,
+		},
+		{
+			name:         "bitrate out of range",
+			targetFormat: "mp3",
+			params:       model.ConversionParams{Bitrate: 512},
+			exp:          errInvalidBitrate,
+		},
+		{
+			name:         "quality for unsupported format",
+			targetFormat: "opus",
+			params:       model.ConversionParams{Quality: quality(2)},
+			exp:          errQualityNotSupported,
+		},
+		{
+			name:         "quality out of range",
+			targetFormat: "mp3",
+			params:       model.ConversionParams{Quality: quality(10)},
+			exp:          errInvalidQuality,
+		},
+		{
+			name:         "unsupported sample rate",
+			targetFormat: "opus",
+			params:       model.ConversionParams{SampleRate: 44100},
+			exp:          errInvalidSampleRate,
+		},
+		{
+			name:         "invalid channels",
+			targetFormat: "flac",
+			params:       model.ConversionParams{Channels: 6},
+			exp:          errInvalidChannels,
+		},
+		{
+			name:         "valid bit depth",
+			targetFormat: "wav",
+			params:       model.ConversionParams{BitDepth: 24},
+			exp:          nil,
+		},
+		{
+			name:         "bit depth for unsupported format",
+			targetFormat: "mp3",
+			params:       model.ConversionParams{BitDepth: 24},
+			exp:          errInvalidBitDepth,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ValidateParams(tt.targetFormat, tt.params)
+			if res != tt.exp {
+				t.Errorf("Expected %v, got %v", tt.exp, res)
+			}
+		})
+	}
+}
+
+// TestValidateAPIKey tests ValidateAPIKey function.
+func TestValidateAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyName string
+		scopes  []string
+		exp     error
+	}{
+		{
+			name:    "valid key",
+			keyName: "batch job",
+			scopes:  []string{model.ScopeRead, model.ScopeWrite},
+			exp:     nil,
+		},
+		{
+			name:    "missing name",
+			keyName: "",
+			scopes:  []string{model.ScopeRead},
+			exp:     errMissingKeyName,
+		},
+		{
+			name:    "missing scopes",
+			keyName: "batch job",
+			scopes:  nil,
+			exp:     errMissingScopes,
+		},
+		{
+			name:    "invalid scope",
+			keyName: "batch job",
+			scopes:  []string{model.ScopeRead, model.RoleAdmin},
+			exp:     errInvalidScope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ValidateAPIKey(tt.keyName, tt.scopes)
+			if res != tt.exp {
+				t.Errorf("Expected %v, got %v", tt.exp, res)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+)
+
+// LocalStorage represents a storage that keeps files in a directory on the local disk.
+// Download URLs are signed with the secret and expire after urlTTL, like presigned S3 URLs.
+type LocalStorage struct {
+	dir     string
+	baseURL string
+	secret  []byte
+	urlTTL  time.Duration
+}
+
+// NewLocalStorage creates new local storage, creating its directory if needed.
+func NewLocalStorage(conf *config.StorageData) (*LocalStorage, error) {
+	if conf.StorageSecret == "" {
+		return nil, errors.New("storage secret is required to sign download URLs of local storage")
+	}
+
+	err := os.MkdirAll(conf.StoragePath, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("can't create storage directory, %w", err)
+	}
+
+	return &LocalStorage{
+		dir:     conf.StoragePath,
+		baseURL: strings.TrimSuffix(conf.StorageURL, "/"),
+		secret:  []byte(conf.StorageSecret),
+		urlTTL:  conf.StorageURLTTL,
+	}, nil
+}
+
+// UploadFile uploads request file.
+func (s *LocalStorage) UploadFile(sourceFile io.Reader, format string) (string, error) {
+	fileID, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("can't generate file uuid, %w", err)
+	}
+	fileIDStr := fileID.String()
+
+	err = s.SaveFile(sourceFile, fileIDStr, format)
+	if err != nil {
+		return "", err
+	}
+
+	return fileIDStr, nil
+}
+
+// SaveFile writes the file to the storage directory, removing the partly written file on failure.
+func (s *LocalStorage) SaveFile(file io.Reader, fileID, format string) error {
+	dst, err := os.Create(s.path(fileID, format))
+	if err != nil {
+		return fmt.Errorf("can't create file in storage, %w", err)
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, file)
+	if err != nil {
+		os.Remove(dst.Name())
+		return fmt.Errorf("can't write file to storage, %w", err)
+	}
+
+	return nil
+}
+
+// GetDownloadURL generates signed URL to download the file served by Handler.
+func (s *LocalStorage) GetDownloadURL(fileID, format string) (string, error) {
+	name := fmt.Sprintf(filenameTmpl, fileID, format)
+	expires := strconv.FormatInt(time.Now().Add(s.urlTTL).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", s.sign(name, expires))
+
+	return s.baseURL + "/" + name + "?" + query.Encode(), nil
+}
+
+// DownloadFile copies the file from the storage directory to the given local path.
+func (s *LocalStorage) DownloadFile(fileID, format, dst string) error {
+	src, err := os.Open(s.path(fileID, format))
+	if err != nil {
+		return fmt.Errorf("can't open file in storage, %w", err)
+	}
+	defer src.Close()
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("can't create local file, %w", err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, src)
+	if err != nil {
+		return fmt.Errorf("can't copy file, %w", err)
+	}
+
+	return nil
+}
+
+// DeleteFile removes the file from the storage directory.
+func (s *LocalStorage) DeleteFile(fileID, format string) error {
+	err := os.Remove(s.path(fileID, format))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("can't delete file from storage, %w", err)
+	}
+
+	return nil
+}
+
+// Handler returns http handler that serves files from the storage directory
+// by their names, without listing the directory.
+// Only the URLs generated by GetDownloadURL that haven't expired are served.
+func (s *LocalStorage) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") || name == "." || name == "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if !s.verify(name, r.URL.Query()) {
+			http.Error(w, "invalid or expired signature", http.StatusForbidden)
+			return
+		}
+
+		http.ServeFile(w, r, filepath.Join(s.dir, name))
+	})
+}
+
+// sign returns the signature of the file name and the expiry time of its URL.
+func (s *LocalStorage) sign(name, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(name + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks that the query contains a valid signature of the file name and the URL hasn't expired.
+func (s *LocalStorage) verify(name string, query url.Values) bool {
+	expires := query.Get("expires")
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+
+	return hmac.Equal([]byte(query.Get("signature")), []byte(s.sign(name, expires)))
+}
+
+func (s *LocalStorage) path(fileID, format string) string {
+	return filepath.Join(s.dir, fmt.Sprintf(filenameTmpl, fileID, format))
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+)
+
+// TestLocalStorage tests uploading, serving and deleting files of LocalStorage.
+func TestLocalStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewLocalStorage(&config.StorageData{StoragePath: dir, StorageURL: "http://localhost/files/",
+		StorageSecret: "secret", StorageURLTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileID, err := s.UploadFile(strings.NewReader("content"), "mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileURL, err := s.GetDownloadURL(fileID, "mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix := "http://localhost/files/" + fileID + ".mp3?"; !strings.HasPrefix(fileURL, prefix) {
+		t.Errorf("Expected URL starting with %s, got %s", prefix, fileURL)
+	}
+	path := strings.TrimPrefix(fileURL, "http://localhost/files")
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "content" {
+		t.Errorf("Expected file content, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+fileID+".mp3", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected %d for unsigned URL, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.Replace(path, fileID, uuid.New().String(), 1), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected %d for URL signed for another file, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	s.urlTTL = -time.Minute
+	expiredURL, err := s.GetDownloadURL(fileID, "mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(expiredURL, "http://localhost/files"), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected %d for expired URL, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected %d for directory listing, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	err = s.DeleteFile(fileID, "mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileID+".mp3")); !os.IsNotExist(err) {
+		t.Errorf("Expected file to be deleted, got %v", err)
+	}
+}
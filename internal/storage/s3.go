@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/google/uuid"
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+)
+
+// S3Storage represents aws s3 client.
+type S3Storage struct {
+	svc        *s3.S3
+	bucket     string
+	uploader   *s3manager.Uploader
+	downloader *s3manager.Downloader
+}
+
+// NewS3Client creates new S3 client.
+func NewS3Client(conf *config.AWSData) (*S3Storage, error) {
+	sess, err := session.NewSession(
+		&aws.Config{
+			Region:      aws.String(conf.Region),
+			Credentials: credentials.NewStaticCredentials(conf.AccessKeyID, conf.SecretAccessKey, ""),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't create new session: %w", err)
+	}
+
+	downloader := s3manager.NewDownloader(sess)
+	uploader := s3manager.NewUploader(sess)
+	svc := s3.New(sess)
+
+	return &S3Storage{
+		svc:        svc,
+		bucket:     conf.Bucket,
+		uploader:   uploader,
+		downloader: downloader,
+	}, nil
+}
+
+// UploadFile uploads request file.
+func (s *S3Storage) UploadFile(sourceFile io.Reader, format string) (string, error) {
+	fileID, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("can't generate file uuid, %w", err)
+	}
+	fileIDStr := fileID.String()
+
+	err = s.SaveFile(sourceFile, fileIDStr, format)
+	if err != nil {
+		return "", err
+	}
+
+	return fileIDStr, nil
+}
+
+// SaveFile uploads the file to s3 cloud storage.
+func (s *S3Storage) SaveFile(file io.Reader, fileID, format string) error {
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fmt.Sprintf(filenameTmpl, fileID, format)),
+		Body:   file,
+	})
+	if err != nil {
+		return fmt.Errorf("can't upload file to S3, %w", err)
+	}
+	return nil
+}
+
+// GetDownloadURL generates presigned URL to download the file from s3 cloud storage.
+func (s *S3Storage) GetDownloadURL(fileID, format string) (string, error) {
+	req, _ := s.svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fmt.Sprintf(filenameTmpl, fileID, format)),
+	})
+	urlStr, err := req.Presign(15 * time.Minute)
+	if err != nil {
+		return "", fmt.Errorf("can't create requets's presigned URL, %w", err)
+	}
+
+	return urlStr, err
+}
+
+// DownloadFile downloads request file from s3 cloud storage to the given local path.
+func (s *S3Storage) DownloadFile(fileID, format, dst string) error {
+	file, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("can't create local file, %w", err)
+	}
+	defer file.Close()
+
+	_, err = s.downloader.Download(file,
+		&s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(fmt.Sprintf(filenameTmpl, fileID, format)),
+		})
+	if err != nil {
+		return fmt.Errorf("can't download file from S3, %w", err)
+	}
+
+	return nil
+}
+
+// DeleteFile deletes the file from s3 cloud storage.
+func (s *S3Storage) DeleteFile(fileID, format string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fmt.Sprintf(filenameTmpl, fileID, format)),
+	})
+	if err != nil {
+		return fmt.Errorf("can't delete file from S3, %w", err)
+	}
+
+	return nil
+}
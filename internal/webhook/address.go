@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned when the webhook URL points to an internal address.
+var ErrForbiddenAddress = errors.New("webhook URL must not point to a loopback, private, link-local or unspecified address")
+
+// ErrRedirect is returned when the webhook receiver responds with a redirect, which isn't followed.
+var ErrRedirect = errors.New("webhook redirects are not followed")
+
+// CheckURL checks that the host of the webhook URL resolves only to public addresses.
+func CheckURL(ctx context.Context, u *url.URL) error {
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublic(ip) {
+			return ErrForbiddenAddress
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("can't resolve host %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if !isPublic(addr.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+
+	return nil
+}
+
+// privateNets are the private and shared address ranges, which are not reachable from the internet.
+var privateNets = parseNets("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func parseNets(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, nets[i], _ = net.ParseCIDR(cidr)
+	}
+	return nets
+}
+
+// isPublic checks whether the address may be reached by webhooks.
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newClient creates an HTTP client that connects only to public addresses and doesn't follow redirects.
+// The address is checked when the connection is made, after the host is resolved,
+// so a host can't pass the check with one address and be reached with another.
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return ErrRedirect
+		},
+	}
+}
@@ -0,0 +1,171 @@
+// Package webhook delivers signed notifications about finished conversions.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/logger"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+// SignatureHeader is the header containing the HMAC-SHA256 signature of the notification body.
+const SignatureHeader = "X-Signature-256"
+
+// Payload represents the body of a webhook notification.
+type Payload struct {
+	RequestID     string    `json:"requestID"`
+	Status        string    `json:"status"`
+	TargetID      string    `json:"targetID,omitempty"`
+	ErrorCode     string    `json:"errorCode,omitempty"`
+	FailureReason string    `json:"failureReason,omitempty"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type store interface {
+	InsertWebhookDelivery(requestID, url string, payload []byte) error
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]model.WebhookDelivery, error)
+	RecordWebhookAttempt(id, status string, statusCode int, deliveryErr string, delay time.Duration) error
+}
+
+// Notifier sends webhook notifications stored in the database, retrying failed deliveries with exponential backoff.
+type Notifier struct {
+	secret       []byte
+	client       *http.Client
+	repo         store
+	attempts     int
+	backoff      time.Duration
+	workers      int
+	pollInterval time.Duration
+	lease        time.Duration
+}
+
+// New creates a new notifier which keeps deliveries and their attempts in the given repository.
+// Notifications are sent only to public addresses and redirects are not followed.
+func New(conf *config.WebhookData, repo store) *Notifier {
+	return &Notifier{
+		secret:       []byte(conf.WebhookSecret),
+		client:       newClient(conf.WebhookTimeout),
+		repo:         repo,
+		attempts:     conf.WebhookAttempts,
+		backoff:      conf.WebhookBackoff,
+		workers:      conf.WebhookWorkers,
+		pollInterval: conf.WebhookPollInterval,
+		// A claimed delivery is sent again only if it hasn't been recorded long after its request timed out.
+		lease: conf.WebhookTimeout + time.Minute,
+	}
+}
+
+// Sign returns the signature of the body made with the given secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send stores the payload to be delivered to the given URL by Run,
+// so that it isn't lost if the receiver is unavailable or the converter stops.
+func (n *Notifier) Send(url string, payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("can't marshal the given payload: %w", err)
+	}
+
+	return n.repo.InsertWebhookDelivery(payload.RequestID, url, body)
+}
+
+// Run sends the stored notifications that are due every poll interval until the context is done.
+// Then it waits for the deliveries in progress, which are limited by the webhook timeout.
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		n.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDue sends the due deliveries in batches of the configured number of concurrent senders
+// until none are left or the context is done.
+func (n *Notifier) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := n.repo.ClaimWebhookDeliveries(n.workers, n.lease)
+		if err != nil {
+			logger.Error(ctx, fmt.Errorf("can't claim webhook deliveries: %w", err))
+			return
+		}
+		if len(deliveries) == 0 {
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, d := range deliveries {
+			wg.Add(1)
+			go func(d model.WebhookDelivery) {
+				defer wg.Done()
+				n.deliver(ctx, d)
+			}(d)
+		}
+		wg.Wait()
+	}
+}
+
+// deliver makes an attempt to send the delivery and records its result.
+// A failed delivery is scheduled again after the backoff doubled with every attempt until attempts run out.
+func (n *Notifier) deliver(ctx context.Context, d model.WebhookDelivery) {
+	// The attempt isn't interrupted on shutdown, the client timeout limits it.
+	statusCode, err := n.send(context.Background(), d.URL, d.Payload, Sign(n.secret, d.Payload))
+
+	status, delay, deliveryErr := model.DeliveryDelivered, time.Duration(0), ""
+	if err != nil {
+		attempt := d.Attempt + 1
+		deliveryErr = err.Error()
+		if attempt >= n.attempts {
+			status = model.DeliveryFailed
+			logger.Error(ctx, fmt.Errorf("can't deliver webhook of request %s after %d attempts: %w", d.RequestID, attempt, err))
+		} else {
+			status, delay = model.DeliveryPending, n.backoff<<(attempt-1)
+		}
+	}
+
+	err = n.repo.RecordWebhookAttempt(d.ID, status, statusCode, deliveryErr, delay)
+	if err != nil {
+		logger.Error(ctx, fmt.Errorf("can't record webhook delivery: %w", err))
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, url string, body []byte, signature string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("can't create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,291 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/katiasuya/audio-conversion-service/internal/config"
+	"github.com/katiasuya/audio-conversion-service/internal/server/model"
+)
+
+type storedDelivery struct {
+	delivery model.WebhookDelivery
+	status   string
+	due      time.Time
+	results  []int
+	err      string
+}
+
+type fakeStore struct {
+	mu         sync.Mutex
+	deliveries []*storedDelivery
+}
+
+func (s *fakeStore) InsertWebhookDelivery(requestID, url string, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = append(s.deliveries, &storedDelivery{
+		delivery: model.WebhookDelivery{ID: strconv.Itoa(len(s.deliveries)), RequestID: requestID, URL: url, Payload: payload},
+		status:   model.DeliveryPending,
+		due:      time.Now(),
+	})
+	return nil
+}
+
+func (s *fakeStore) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed []model.WebhookDelivery
+	for _, d := range s.deliveries {
+		if len(claimed) < limit && d.status == model.DeliveryPending && !d.due.After(time.Now()) {
+			d.due = time.Now().Add(lease)
+			claimed = append(claimed, d.delivery)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *fakeStore) RecordWebhookAttempt(id, status string, statusCode int, deliveryErr string, delay time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.deliveries {
+		if d.delivery.ID == id {
+			d.delivery.Attempt++
+			d.status = status
+			d.due = time.Now().Add(delay)
+			d.results = append(d.results, statusCode)
+			d.err = deliveryErr
+		}
+	}
+	return nil
+}
+
+// get returns a copy of the stored delivery.
+func (s *fakeStore) get(i int) storedDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.deliveries[i]
+}
+
+// TestRun tests that stored notifications are signed and sent in the background
+// and that failed deliveries are retried until attempts run out.
+func TestRun(t *testing.T) {
+	secret := []byte("secret")
+
+	tests := []struct {
+		name        string
+		failures    int
+		attempts    int
+		expAttempts int
+		expStatus   string
+	}{
+		{
+			name:        "first attempt succeeds",
+			failures:    0,
+			attempts:    3,
+			expAttempts: 1,
+			expStatus:   model.DeliveryDelivered,
+		},
+		{
+			name:        "retry succeeds",
+			failures:    2,
+			attempts:    3,
+			expAttempts: 3,
+			expStatus:   model.DeliveryDelivered,
+		},
+		{
+			name:        "attempts run out",
+			failures:    3,
+			attempts:    3,
+			expAttempts: 3,
+			expStatus:   model.DeliveryFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+				body, _ := ioutil.ReadAll(r.Body)
+				if r.Header.Get(SignatureHeader) != Sign(secret, body) {
+					t.Errorf("Invalid signature %s", r.Header.Get(SignatureHeader))
+				}
+
+				var payload Payload
+				if err := json.Unmarshal(body, &payload); err != nil || payload.RequestID != "request-id" {
+					t.Errorf("Invalid payload %s", body)
+				}
+
+				if calls <= tt.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			store := &fakeStore{}
+			n := &Notifier{
+				secret:       secret,
+				client:       srv.Client(),
+				repo:         store,
+				attempts:     tt.attempts,
+				backoff:      time.Millisecond,
+				workers:      2,
+				pollInterval: time.Millisecond,
+				lease:        time.Minute,
+			}
+
+			err := n.Send(srv.URL, Payload{RequestID: "request-id", Status: "done"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			go func() {
+				n.Run(ctx)
+				close(stopped)
+			}()
+
+			deadline := time.Now().Add(time.Second)
+			for store.get(0).status == model.DeliveryPending && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			<-stopped
+
+			d := store.get(0)
+			if d.status != tt.expStatus {
+				t.Fatalf("Expected status %s, got %s", tt.expStatus, d.status)
+			}
+			if d.delivery.Attempt != tt.expAttempts {
+				t.Fatalf("Expected %d attempts, got %d", tt.expAttempts, d.delivery.Attempt)
+			}
+
+			last := d.results[len(d.results)-1]
+			if tt.expStatus == model.DeliveryDelivered && (last != http.StatusNoContent || d.err != "") {
+				t.Errorf("Expected successful last attempt, got %d %q", last, d.err)
+			}
+			if tt.expStatus == model.DeliveryFailed && last != http.StatusServiceUnavailable {
+				t.Errorf("Expected failed last attempt, got %d", last)
+			}
+		})
+	}
+}
+
+// TestRunInterruptedDelivery tests that a delivery claimed by a notifier that stopped
+// before recording the attempt is sent again when its lease ends.
+func TestRunInterruptedDelivery(t *testing.T) {
+	store := &fakeStore{}
+	store.InsertWebhookDelivery("request-id", "http://example.com", []byte("{}"))
+
+	claimed, _ := store.ClaimWebhookDeliveries(1, time.Millisecond)
+	if len(claimed) != 1 {
+		t.Fatalf("Expected 1 claimed delivery, got %d", len(claimed))
+	}
+	if claimed, _ := store.ClaimWebhookDeliveries(1, time.Millisecond); len(claimed) != 0 {
+		t.Fatalf("Expected the claimed delivery to be leased, got %d", len(claimed))
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if claimed, _ := store.ClaimWebhookDeliveries(1, time.Millisecond); len(claimed) != 1 {
+		t.Errorf("Expected the delivery to be claimed again after the lease, got %d", len(claimed))
+	}
+}
+
+// TestDeliverInternalAddress tests that the notifier doesn't connect to internal addresses.
+func TestDeliverInternalAddress(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+
+	store := &fakeStore{}
+	n := New(&config.WebhookData{WebhookAttempts: 1, WebhookTimeout: time.Second, WebhookWorkers: 1}, store)
+
+	err := n.Send(srv.URL, Payload{RequestID: "request-id", Status: "done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.deliverDue(context.Background())
+
+	d := store.get(0)
+	if d.status != model.DeliveryFailed || !strings.Contains(d.err, ErrForbiddenAddress.Error()) {
+		t.Errorf("Expected failed delivery with %v, got %s %q", ErrForbiddenAddress, d.status, d.err)
+	}
+	if calls != 0 {
+		t.Errorf("Expected no calls, got %d", calls)
+	}
+}
+
+// TestCheckURL tests that CheckURL rejects internal addresses.
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		expErr error
+	}{
+		{
+			name:   "public",
+			url:    "https://93.184.216.34/hook",
+			expErr: nil,
+		},
+		{
+			name:   "loopback",
+			url:    "http://127.0.0.1:8000/hook",
+			expErr: ErrForbiddenAddress,
+		},
+		{
+			name:   "cloud metadata",
+			url:    "http://169.254.169.254/latest/meta-data",
+			expErr: ErrForbiddenAddress,
+		},
+		{
+			name:   "private",
+			url:    "http://10.1.2.3/hook",
+			expErr: ErrForbiddenAddress,
+		},
+		{
+			name:   "unspecified",
+			url:    "http://0.0.0.0/hook",
+			expErr: ErrForbiddenAddress,
+		},
+		{
+			name:   "ipv6 loopback",
+			url:    "http://[::1]/hook",
+			expErr: ErrForbiddenAddress,
+		},
+		{
+			name:   "ipv4-mapped private",
+			url:    "http://[::ffff:192.168.0.1]/hook",
+			expErr: ErrForbiddenAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := CheckURL(context.Background(), u); err != tt.expErr {
+				t.Errorf("Expected %v, got %v", tt.expErr, err)
+			}
+		})
+	}
+}
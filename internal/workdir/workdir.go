@@ -0,0 +1,41 @@
+// Package workdir manages the local directory for temporary files of uploads and conversions.
+package workdir
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Prepare creates the work directory if needed and removes the files and directories in it
+// that are older than maxAge. They are left by processes that were stopped while handling a job.
+// The age limit keeps the files of the jobs running in other processes sharing the directory.
+// It returns the number of removed entries.
+func Prepare(dir string, maxAge time.Duration) (int, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return 0, fmt.Errorf("can't create work directory: %w", err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("can't read work directory: %w", err)
+	}
+
+	var removed int
+	deadline := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		if entry.ModTime().After(deadline) {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return removed, fmt.Errorf("can't remove orphaned %s: %w", entry.Name(), err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
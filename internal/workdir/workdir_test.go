@@ -0,0 +1,53 @@
+package workdir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPrepare tests that Prepare removes only the entries older than the age limit.
+func TestPrepare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "job-old")
+	err = os.Mkdir(old, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(old, "source.mp3"), []byte("content"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldTime := time.Now().Add(-2 * time.Hour)
+	err = os.Chtimes(old, oldTime, oldTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recent := filepath.Join(dir, "upload-recent")
+	err = ioutil.WriteFile(recent, []byte("content"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := Prepare(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected %d, got %d", 1, removed)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", old, err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("Expected %s to be kept, got %v", recent, err)
+	}
+}
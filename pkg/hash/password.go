@@ -1,4 +1,4 @@
-// Package hash provide functions to hash and compare passwords.
+// Package hash provide functions to hash and compare passwords and tokens.
 package hash
 
 import (
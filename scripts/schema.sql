@@ -24,14 +24,43 @@ CREATE SCHEMA IF NOT EXISTS converter;
 DO $$
 BEGIN
     IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'format') THEN
-        CREATE TYPE format AS ENUM ('mp3', 'wav');
+        CREATE TYPE format AS ENUM ('mp3', 'wav', 'flac', 'ogg', 'opus', 'm4a', 'aac');
     END IF;
 END$$;
 
+ALTER TYPE format ADD VALUE IF NOT EXISTS 'flac';
+ALTER TYPE format ADD VALUE IF NOT EXISTS 'ogg';
+ALTER TYPE format ADD VALUE IF NOT EXISTS 'opus';
+ALTER TYPE format ADD VALUE IF NOT EXISTS 'm4a';
+ALTER TYPE format ADD VALUE IF NOT EXISTS 'aac';
+
 DO $$
 BEGIN
     IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'status') THEN
-        CREATE TYPE status AS ENUM ('queued', 'processing','done', 'failed');
+        CREATE TYPE status AS ENUM ('queued', 'processing','done', 'failed', 'cancelled');
+    END IF;
+END$$;
+
+ALTER TYPE status ADD VALUE IF NOT EXISTS 'cancelled';
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'user_role') THEN
+        CREATE TYPE user_role AS ENUM ('user', 'admin');
+    END IF;
+END$$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'user_tier') THEN
+        CREATE TYPE user_tier AS ENUM ('free', 'premium');
+    END IF;
+END$$;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'delivery_status') THEN
+        CREATE TYPE delivery_status AS ENUM ('pending', 'delivered', 'failed');
     END IF;
 END$$;
 
@@ -39,30 +68,188 @@ CREATE TABLE IF NOT EXISTS converter."user"(
 id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
 username TEXT UNIQUE NOT NULL,
 password TEXT NOT NULL,
+role user_role DEFAULT 'user' NOT NULL,
+tier user_tier DEFAULT 'free' NOT NULL,
 created TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
 updated TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL
 );
 
+ALTER TABLE converter."user" ADD COLUMN IF NOT EXISTS role user_role DEFAULT 'user' NOT NULL;
+ALTER TABLE converter."user" ADD COLUMN IF NOT EXISTS tier user_tier DEFAULT 'free' NOT NULL;
+
 CREATE TABLE IF NOT EXISTS converter.audio (
 id UUID  DEFAULT gen_random_uuid() PRIMARY KEY,
 name TEXT NOT NULL,
 format format NOT NULL,
 location TEXT NOT NULL,
+size BIGINT,
+duration DOUBLE PRECISION,
+checksum TEXT,
+codec TEXT,
+channels SMALLINT,
+sample_rate INTEGER,
+bitrate INTEGER,
+expired TIMESTAMP WITHOUT TIME ZONE,
 created TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
 updated TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL
 );
 
+ALTER TABLE converter.audio ADD COLUMN IF NOT EXISTS size BIGINT;
+ALTER TABLE converter.audio ADD COLUMN IF NOT EXISTS duration DOUBLE PRECISION;
+ALTER TABLE converter.audio ADD COLUMN IF NOT EXISTS checksum TEXT;
+ALTER TABLE converter.audio ADD COLUMN IF NOT EXISTS codec TEXT;
+ALTER TABLE converter.audio ADD COLUMN IF NOT EXISTS channels SMALLINT;
+ALTER TABLE converter.audio ADD COLUMN IF NOT EXISTS sample_rate INTEGER;
+ALTER TABLE converter.audio ADD COLUMN IF NOT EXISTS bitrate INTEGER;
+ALTER TABLE converter.audio ADD COLUMN IF NOT EXISTS expired TIMESTAMP WITHOUT TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS audio_created_not_expired_idx ON converter.audio (created) WHERE expired IS NULL;
+
 CREATE TABLE IF NOT EXISTS converter.request (
 id UUID  DEFAULT gen_random_uuid() PRIMARY KEY,
 user_id UUID NOT NULL,
-source_id UUID NOT NULL,
+source_id UUID,
 source_format format NOT NULL,
 target_id UUID,
 target_format format NOT NULL,
 created TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
 updated TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
 status status NOT NULL,
+bitrate INTEGER,
+quality SMALLINT,
+sample_rate INTEGER,
+channels SMALLINT,
+bit_depth SMALLINT,
+error_code TEXT,
+failure_reason TEXT,
+callback_url TEXT,
+duration DOUBLE PRECISION,
+progress SMALLINT,
 FOREIGN KEY (user_id) REFERENCES converter."user" (id) ON DELETE CASCADE,
-FOREIGN KEY (source_id) REFERENCES converter.audio (id) ON DELETE CASCADE,
-FOREIGN KEY (target_id) REFERENCES converter.audio (id) ON DELETE CASCADE
+FOREIGN KEY (source_id) REFERENCES converter.audio (id) ON DELETE SET NULL,
+FOREIGN KEY (target_id) REFERENCES converter.audio (id) ON DELETE SET NULL
+);
+
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS bitrate INTEGER;
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS quality SMALLINT;
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS sample_rate INTEGER;
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS channels SMALLINT;
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS bit_depth SMALLINT;
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS failure_reason TEXT;
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS error_code TEXT;
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS callback_url TEXT;
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS duration DOUBLE PRECISION;
+ALTER TABLE converter.request ADD COLUMN IF NOT EXISTS progress SMALLINT;
+
+UPDATE converter.request r SET duration=a.duration FROM converter.audio a
+WHERE a.id = r.source_id AND r.duration IS NULL AND a.duration IS NOT NULL;
+
+ALTER TABLE converter.request ALTER COLUMN source_id DROP NOT NULL;
+ALTER TABLE converter.request DROP CONSTRAINT IF EXISTS request_source_id_fkey,
+ADD CONSTRAINT request_source_id_fkey FOREIGN KEY (source_id) REFERENCES converter.audio (id) ON DELETE SET NULL;
+ALTER TABLE converter.request DROP CONSTRAINT IF EXISTS request_target_id_fkey,
+ADD CONSTRAINT request_target_id_fkey FOREIGN KEY (target_id) REFERENCES converter.audio (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS request_user_id_created_idx ON converter.request (user_id, created, id);
+CREATE INDEX IF NOT EXISTS request_user_id_status_created_idx ON converter.request (user_id, status, created, id);
+
+CREATE TABLE IF NOT EXISTS converter.webhook_delivery (
+id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+request_id UUID NOT NULL,
+url TEXT NOT NULL,
+payload JSONB,
+status delivery_status DEFAULT 'pending' NOT NULL,
+attempt SMALLINT DEFAULT 0 NOT NULL,
+next_attempt TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
+status_code SMALLINT,
+error TEXT,
+created TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
+updated TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
+FOREIGN KEY (request_id) REFERENCES converter.request (id) ON DELETE CASCADE
+);
+
+-- Rows recorded before the outbox are attempts that have already been made, so they are not sent again.
+ALTER TABLE converter.webhook_delivery ADD COLUMN IF NOT EXISTS payload JSONB;
+ALTER TABLE converter.webhook_delivery ADD COLUMN IF NOT EXISTS status delivery_status DEFAULT 'delivered' NOT NULL;
+ALTER TABLE converter.webhook_delivery ALTER COLUMN status SET DEFAULT 'pending';
+ALTER TABLE converter.webhook_delivery ALTER COLUMN attempt SET DEFAULT 0;
+ALTER TABLE converter.webhook_delivery ADD COLUMN IF NOT EXISTS next_attempt TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL;
+ALTER TABLE converter.webhook_delivery ADD COLUMN IF NOT EXISTS updated TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL;
+
+CREATE INDEX IF NOT EXISTS webhook_delivery_pending_idx ON converter.webhook_delivery (next_attempt)
+WHERE status = 'pending';
+
+CREATE OR REPLACE FUNCTION converter.notify_request_status()
+RETURNS TRIGGER
+LANGUAGE plpgsql
+AS
+$$
+BEGIN
+    IF TG_OP = 'INSERT' OR NEW.status IS DISTINCT FROM OLD.status THEN
+        PERFORM pg_notify('request_status', json_build_object(
+            'requestID', NEW.id,
+            'userID', NEW.user_id,
+            'status', NEW.status,
+            'updated', NEW.updated::timestamptz)::text);
+    END IF;
+    RETURN NEW;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS request_status_notify ON converter.request;
+CREATE TRIGGER request_status_notify
+AFTER INSERT OR UPDATE ON converter.request
+FOR EACH ROW EXECUTE FUNCTION converter.notify_request_status();
+
+CREATE TABLE IF NOT EXISTS converter.replay_audit (
+id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+request_id UUID NOT NULL,
+admin_id UUID NOT NULL,
+previous_status status NOT NULL,
+created TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
+FOREIGN KEY (request_id) REFERENCES converter.request (id) ON DELETE CASCADE,
+FOREIGN KEY (admin_id) REFERENCES converter."user" (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS converter.refresh_token (
+id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+user_id UUID NOT NULL,
+family_id UUID NOT NULL,
+token_hash TEXT UNIQUE NOT NULL,
+expires TIMESTAMP WITH TIME ZONE NOT NULL,
+used BOOLEAN DEFAULT FALSE NOT NULL,
+revoked BOOLEAN DEFAULT FALSE NOT NULL,
+created TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
+FOREIGN KEY (user_id) REFERENCES converter."user" (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS refresh_token_family_id_idx ON converter.refresh_token (family_id);
+
+CREATE TABLE IF NOT EXISTS converter.revoked_token (
+jti UUID PRIMARY KEY,
+expires TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS converter.api_key (
+id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+user_id UUID NOT NULL,
+name TEXT NOT NULL,
+prefix TEXT NOT NULL,
+key_hash TEXT UNIQUE NOT NULL,
+scopes TEXT[] NOT NULL,
+last_used TIMESTAMP WITHOUT TIME ZONE,
+created TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
+FOREIGN KEY (user_id) REFERENCES converter."user" (id) ON DELETE CASCADE
+);
+
+-- Every upload to POST /conversion is recorded before its body is read, so that concurrent uploads
+-- are counted towards the limits of the user. An attempt is finished when the upload request ends.
+CREATE TABLE IF NOT EXISTS converter.upload_attempt (
+id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+user_id UUID NOT NULL,
+created TIMESTAMP WITHOUT TIME ZONE DEFAULT NOW() NOT NULL,
+finished TIMESTAMP WITHOUT TIME ZONE,
+FOREIGN KEY (user_id) REFERENCES converter."user" (id) ON DELETE CASCADE
 );
+
+CREATE INDEX IF NOT EXISTS upload_attempt_user_id_created_idx ON converter.upload_attempt (user_id, created);